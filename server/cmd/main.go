@@ -1,65 +1,257 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"csr-volunteer-matching/internal/config"
 	"csr-volunteer-matching/internal/handler"
+	"csr-volunteer-matching/internal/logging"
+	"csr-volunteer-matching/internal/mailer"
+	"csr-volunteer-matching/internal/metrics"
+	"csr-volunteer-matching/internal/moderation"
+	"csr-volunteer-matching/internal/policy"
+	"csr-volunteer-matching/internal/ratelimit"
 	"csr-volunteer-matching/internal/repository"
+	"csr-volunteer-matching/internal/scheduler"
 	"csr-volunteer-matching/internal/service"
-	"fmt"
-	"log"
+	"csr-volunteer-matching/internal/tenancy"
+	"csr-volunteer-matching/internal/tracing"
+	"encoding/hex"
+	"errors"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+const serviceName = "csr-volunteer-api"
+
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// readyz answers readiness probes. Once shutdown begins it reports draining
+// without running the checks, so load balancers stop sending new requests
+// while in-flight ones finish.
+func readyz(draining *atomic.Bool, readiness func(context.Context) (map[string]interface{}, bool)) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if draining.Load() {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "draining"})
+			return
+		}
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 2*time.Second)
+		defer cancel()
+		checks, ready := readiness(ctx)
+		status := http.StatusOK
+		checks["status"] = "ready"
+		if !ready {
+			status = http.StatusServiceUnavailable
+			checks["status"] = "not_ready"
+		}
+		c.JSON(status, checks)
+	}
+}
+
 func main() {
 	cfg := config.LoadConfig()
-	fmt.Println("Booting server...")
+	logger := logging.New(cfg.LogLevel)
+	logger.Info("Booting server...")
+
+	// Cancelled on SIGINT/SIGTERM to begin a graceful shutdown.
+	signalCtx, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	// Cancelled once the HTTP server has drained, to stop background workers.
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	var workers sync.WaitGroup
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TraceExporter, cfg.TraceEndpoint, serviceName, cfg.TraceSampleRatio)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
 
 	var gormdb *gorm.DB
-	var err error
 	if cfg.DatabaseURL != "" {
-		fmt.Println("Establishing connection to the database...")
-		gormdb, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{})
+		logger.Info("Establishing connection to the database...")
+		gormdb, err = gorm.Open(postgres.Open(cfg.DatabaseURL), &gorm.Config{Logger: logging.NewGormLogger(), TranslateError: true})
 		if err != nil {
-			log.Fatalf("Failed to connect to database: %v", err)
+			fatal("Failed to connect to database", err)
 		}
+		if err := gormdb.Use(metrics.GormPlugin{}); err != nil {
+			fatal("Failed to register query metrics", err)
+		}
+		if err := gormdb.Use(tracing.GormPlugin{}); err != nil {
+			fatal("Failed to register query tracing", err)
+		}
+		if err := gormdb.Use(tenancy.GormPlugin{}); err != nil {
+			fatal("Failed to register tenant scoping", err)
+		}
+		sqlDB, err := gormdb.DB()
+		if err != nil {
+			fatal("Failed to access database pool", err)
+		}
+		metrics.RegisterDBStats(sqlDB)
 	} else {
-		fmt.Println("DATABASE_URL is empty; starting without a DB connection.")
+		logger.Warn("DATABASE_URL is empty; starting without a DB connection.")
+	}
+
+	if cfg.TokenSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			fatal("Failed to generate token secret", err)
+		}
+		cfg.TokenSecret = hex.EncodeToString(secret)
+		logger.Warn("TOKEN_SECRET is empty; using a random secret, emailed links and 2FA enrollments will not survive a restart")
+	}
+	mail, err := mailer.New(cfg.Mailer, cfg.MailFrom, cfg.MailOutboxDir, cfg.SMTPAddr, cfg.SMTPUsername, cfg.SMTPPassword)
+	if err != nil {
+		fatal("Failed to set up mailer", err)
+	}
+
+	pol := policy.Default()
+	if cfg.PolicyFile != "" {
+		if pol, err = policy.LoadFile(cfg.PolicyFile); err != nil {
+			fatal("Failed to load permission policy", err)
+		}
+	}
+
+	screener, err := moderation.NewScreener(cfg.RequestModeration, cfg.ProhibitedTerms)
+	if err != nil {
+		fatal("Invalid REQUEST_MODERATION", err)
 	}
 
-	repo := repository.NewRepository(gormdb)
-	svc := service.NewService(repo)
-	h := handler.NewHandler(svc)
+	repo := repository.NewRepository(gormdb).WithScreener(screener)
+	svc := service.NewService(repo, cfg, mail, pol)
+
+	var limiterStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore == "postgres" && gormdb != nil {
+		limiterStore = ratelimit.StoreFunc(repo.TakeRateLimitToken)
+	}
+	h := handler.NewHandler(svc, cfg, ratelimit.New(limiterStore))
 
 	// Auto-migrate database
 	if gormdb != nil {
 		if err := svc.AutoMigrate(); err != nil {
-			log.Fatalf("Failed to migrate database: %v", err)
+			fatal("Failed to migrate database", err)
 		}
-		fmt.Println("Database migration completed successfully")
+		logger.Info("Database migration completed successfully")
+
+		sched := scheduler.New()
+		schedules := map[string]string{
+			"daily":   cfg.ReportScheduleDaily,
+			"weekly":  cfg.ReportScheduleWeekly,
+			"monthly": cfg.ReportScheduleMonthly,
+		}
+		for _, reportType := range service.ReportTypes {
+			reportType := reportType
+			err := sched.Add(reportType+"-report", schedules[reportType], true, func(ctx context.Context, now time.Time) error {
+				n, err := svc.GenerateDueReports(ctx, reportType, now)
+				if n > 0 {
+					logger.Info("Generated scheduled reports", "type", reportType, "count", n)
+				}
+				return err
+			})
+			if err != nil {
+				fatal("Invalid "+reportType+" report schedule", err)
+			}
+		}
+		if cfg.RateLimitStore == "postgres" {
+			err := sched.Add("rate-limit-cleanup", "@hourly", false, func(ctx context.Context, now time.Time) error {
+				return repo.WithContext(ctx).PruneRateLimitBuckets(now.Add(-24 * time.Hour))
+			})
+			if err != nil {
+				fatal("Invalid rate limit cleanup schedule", err)
+			}
+		}
+		sched.Start(workerCtx)
+		workers.Add(2)
+		go func() {
+			defer workers.Done()
+			sched.Wait()
+		}()
+		go func() {
+			defer workers.Done()
+			svc.RunViewTracker(workerCtx)
+		}()
 	}
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(logging.Middleware(), gin.Recovery(), metrics.GinMiddleware())
+	router.Use(tracing.Middleware(serviceName)...)
 
 	c := cors.DefaultConfig()
 	c.AllowOrigins = cfg.AllowOrigins
 	c.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
-	c.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	c.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "X-API-Key", "X-Tenant", logging.RequestIDHeader}
+	c.ExposeHeaders = []string{logging.RequestIDHeader, tracing.TraceIDHeader, "X-Impersonated-By"}
 	c.AllowCredentials = true
 	router.Use(cors.New(c))
 
 	// base health for LB
+	var draining atomic.Bool
 	router.GET("/healthz", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/livez", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	router.GET("/readyz", readyz(&draining, svc.Readiness))
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	// Register all routes
 	h.RegisterRoutes(router)
 
-	fmt.Printf("Starting server on %s\n", cfg.ServerAddress)
-	if err := router.Run(cfg.ServerAddress); err != nil {
-		log.Fatalf("Failed to run the server: %v", err)
+	srv := &http.Server{Addr: cfg.ServerAddress, Handler: router}
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Starting server", "address", cfg.ServerAddress)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverErr <- err
+		}
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Failed to run the server", err)
+	case <-signalCtx.Done():
+	}
+
+	logger.Info("Shutting down", "timeout", cfg.ShutdownTimeout.String())
+	draining.Store(true)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting connections and wait for in-flight requests, then stop
+	// the workers so the view tracker flushes what those requests queued.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("HTTP server did not drain in time", "error", err)
+	}
+	stopWorkers()
+	workersDone := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(workersDone)
+	}()
+	select {
+	case <-workersDone:
+	case <-shutdownCtx.Done():
+		logger.Error("Background workers did not stop in time")
+	}
+
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+	if gormdb != nil {
+		if sqlDB, err := gormdb.DB(); err == nil {
+			sqlDB.Close()
+		}
 	}
+	logger.Info("Server stopped")
 }
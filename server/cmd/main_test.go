@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestReadyz(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name       string
+		draining   bool
+		ready      bool
+		wantCode   int
+		wantStatus string
+		wantChecks bool
+	}{
+		{"ready", false, true, http.StatusOK, "ready", true},
+		{"checks failing", false, false, http.StatusServiceUnavailable, "not_ready", true},
+		{"draining", true, true, http.StatusServiceUnavailable, "draining", false},
+	}
+	for _, tt := range tests {
+		var draining atomic.Bool
+		draining.Store(tt.draining)
+		checked := false
+		readiness := func(ctx context.Context) (map[string]interface{}, bool) {
+			checked = true
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("%s: readiness checks run without a deadline", tt.name)
+			}
+			return map[string]interface{}{"database": "ok"}, tt.ready
+		}
+		router := gin.New()
+		router.GET("/readyz", readyz(&draining, readiness))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		if w.Code != tt.wantCode {
+			t.Errorf("%s: code = %d, want %d", tt.name, w.Code, tt.wantCode)
+		}
+		var body map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: decode body: %v", tt.name, err)
+		}
+		if body["status"] != tt.wantStatus {
+			t.Errorf("%s: status = %v, want %q", tt.name, body["status"], tt.wantStatus)
+		}
+		if checked != tt.wantChecks {
+			t.Errorf("%s: checks ran = %v, want %v", tt.name, checked, tt.wantChecks)
+		}
+		if _, ok := body["database"]; ok != tt.wantChecks {
+			t.Errorf("%s: body = %v, database check reported = %v, want %v", tt.name, body, ok, tt.wantChecks)
+		}
+	}
+}
+
+func TestReadyzStartsDraining(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var draining atomic.Bool
+	router := gin.New()
+	router.GET("/readyz", readyz(&draining, func(context.Context) (map[string]interface{}, bool) {
+		return map[string]interface{}{}, true
+	}))
+	probe := func() int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+	if code := probe(); code != http.StatusOK {
+		t.Fatalf("before shutdown: code = %d, want %d", code, http.StatusOK)
+	}
+	draining.Store(true)
+	if code := probe(); code != http.StatusServiceUnavailable {
+		t.Errorf("after shutdown began: code = %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
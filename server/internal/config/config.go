@@ -2,13 +2,96 @@ package config
 
 import (
     "os"
+    "path/filepath"
+    "strconv"
     "strings"
+    "time"
 )
 
 type Config struct {
     ServerAddress string
+    // How long shutdown waits for in-flight requests and background workers.
+    ShutdownTimeout time.Duration
     DatabaseURL   string
     AllowOrigins  []string
+    // One of debug, info, warn or error. Debug also logs every SQL statement.
+    LogLevel string
+
+    // Auth throttling. RateLimitStore is "memory" or "postgres" (shared across
+    // instances). Login limits are per minute, registration per hour.
+    RateLimitStore            string
+    LoginRateLimitPerIP       int
+    LoginRateLimitPerUsername int
+    RegisterRateLimitPerIP    int
+    // After LockoutThreshold consecutive failures an account is locked for
+    // LockoutBaseDuration, doubling with each further failure up to the max.
+    LockoutThreshold    int
+    LockoutBaseDuration time.Duration
+    LockoutMaxDuration  time.Duration
+
+    // Secret used to sign emailed tokens; must be stable across restarts and
+    // shared by all instances.
+    TokenSecret string
+    // Public URL of the app, used to build links in emails.
+    AppBaseURL           string
+    PasswordResetTTL     time.Duration
+    EmailVerificationTTL time.Duration
+    InvitationTTL        time.Duration
+    // Lifetime of the tokens admins get when impersonating a user.
+    ImpersonationTTL time.Duration
+    // Issuer name shown in authenticator apps.
+    TwoFactorIssuer string
+    // Lifetime of sessions created by single sign-on, and where the browser
+    // is sent afterwards (the token is passed in the URL fragment). Without
+    // a redirect URL the callback answers with JSON.
+    SSOSessionTTL         time.Duration
+    SSOSuccessRedirectURL string
+    // Optional JSON file overriding the built-in role-to-permission mappings.
+    PolicyFile string
+    // Parent domain of tenant subdomains, e.g. "csr.example.org" so that
+    // "north.csr.example.org" selects the "north" tenant. Empty disables
+    // subdomain resolution; the X-Tenant header always works.
+    TenantBaseDomain string
+    // Locale of the base names and descriptions of service categories;
+    // translations cover other locales.
+    DefaultLocale string
+    // When set, CSR reps cannot create matches until their email is verified.
+    RequireVerifiedEmailForMatching bool
+    // Request moderation: "off", "flagged" (hold requests the pre-screen
+    // flags for contact details or prohibited terms) or "all" (hold every
+    // new or edited request until reviewed).
+    RequestModeration string
+    ProhibitedTerms   []string
+
+    // Mail delivery: "file" writes messages to MailOutboxDir, "smtp" sends them.
+    Mailer        string
+    MailFrom      string
+    MailOutboxDir string
+    SMTPAddr      string
+    SMTPUsername  string
+    SMTPPassword  string
+
+    // Tracing: exporter is none, otlp or stdout. An empty endpoint falls back
+    // to the standard OTEL_EXPORTER_OTLP_* variables.
+    TraceExporter    string
+    TraceEndpoint    string
+    TraceSampleRatio float64
+
+    // Cron expressions for automatic report generation; empty disables a type.
+    // Set the variable to "off" to disable a default schedule.
+    ReportScheduleDaily   string
+    ReportScheduleWeekly  string
+    ReportScheduleMonthly string
+    // How many past periods of each type are backfilled after downtime.
+    ReportBackfillLimit int
+
+    // Repeat views of a request by the same rep within this window are not
+    // counted again.
+    ViewDedupWindow time.Duration
+    // View logs are written asynchronously in batches of up to this size, or
+    // whenever the flush interval elapses.
+    ViewLogBatchSize     int
+    ViewLogFlushInterval time.Duration
 }
 
 func getenv(key, def string) string {
@@ -18,11 +101,92 @@ func getenv(key, def string) string {
     return def
 }
 
+func getenvInt(key string, def int) int {
+    if v, err := strconv.Atoi(os.Getenv(key)); err == nil {
+        return v
+    }
+    return def
+}
+
+func getenvBool(key string, def bool) bool {
+    if v, err := strconv.ParseBool(os.Getenv(key)); err == nil {
+        return v
+    }
+    return def
+}
+
+func getenvFloat(key string, def float64) float64 {
+    if v, err := strconv.ParseFloat(os.Getenv(key), 64); err == nil {
+        return v
+    }
+    return def
+}
+
+func getenvDuration(key string, def time.Duration) time.Duration {
+    if v, err := time.ParseDuration(os.Getenv(key)); err == nil {
+        return v
+    }
+    return def
+}
+
+func getschedule(key, def string) string {
+    v := getenv(key, def)
+    if v == "off" {
+        return ""
+    }
+    return v
+}
+
 func LoadConfig() *Config {
     return &Config{
         ServerAddress: getenv("SERVER_ADDRESS", ":8080"),
+        ShutdownTimeout: getenvDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
         DatabaseURL:   getenv("DATABASE_URL", ""),
         AllowOrigins:  strings.Split(getenv("CORS_ALLOW_ORIGINS", "http://127.0.0.1:5500,http://127.0.0.1:5501"), ","),
+        LogLevel:      getenv("LOG_LEVEL", "info"),
+
+        RateLimitStore:            getenv("RATE_LIMIT_STORE", "memory"),
+        LoginRateLimitPerIP:       getenvInt("LOGIN_RATE_LIMIT_PER_IP", 20),
+        LoginRateLimitPerUsername: getenvInt("LOGIN_RATE_LIMIT_PER_USERNAME", 5),
+        RegisterRateLimitPerIP:    getenvInt("REGISTER_RATE_LIMIT_PER_IP", 10),
+        LockoutThreshold:          getenvInt("LOCKOUT_THRESHOLD", 5),
+        LockoutBaseDuration:       getenvDuration("LOCKOUT_BASE_DURATION", time.Minute),
+        LockoutMaxDuration:        getenvDuration("LOCKOUT_MAX_DURATION", time.Hour),
+
+        TokenSecret:                     getenv("TOKEN_SECRET", ""),
+        AppBaseURL:                      strings.TrimRight(getenv("APP_BASE_URL", "http://localhost:8080"), "/"),
+        PasswordResetTTL:                getenvDuration("PASSWORD_RESET_TTL", time.Hour),
+        EmailVerificationTTL:            getenvDuration("EMAIL_VERIFICATION_TTL", 48*time.Hour),
+        InvitationTTL:                   getenvDuration("INVITATION_TTL", 7*24*time.Hour),
+        ImpersonationTTL:                getenvDuration("IMPERSONATION_TTL", 15*time.Minute),
+        TwoFactorIssuer:                 getenv("TWO_FACTOR_ISSUER", "CSR Volunteer"),
+        SSOSessionTTL:                   getenvDuration("SSO_SESSION_TTL", 12*time.Hour),
+        SSOSuccessRedirectURL:           getenv("SSO_SUCCESS_REDIRECT_URL", ""),
+        PolicyFile:                      getenv("POLICY_FILE", ""),
+        TenantBaseDomain:                strings.ToLower(strings.Trim(getenv("TENANT_BASE_DOMAIN", ""), ".")),
+        DefaultLocale:                   strings.ToLower(getenv("DEFAULT_LOCALE", "en")),
+        RequireVerifiedEmailForMatching: getenvBool("REQUIRE_VERIFIED_EMAIL_FOR_MATCHING", false),
+        RequestModeration:               strings.ToLower(getenv("REQUEST_MODERATION", "off")),
+        ProhibitedTerms:                 strings.Split(getenv("PROHIBITED_TERMS", ""), ","),
+
+        Mailer:        getenv("MAILER", "file"),
+        MailFrom:      getenv("MAIL_FROM", "no-reply@csr-volunteer.local"),
+        MailOutboxDir: getenv("MAIL_OUTBOX_DIR", filepath.Join(os.TempDir(), "csr-outbox")),
+        SMTPAddr:      getenv("SMTP_ADDR", ""),
+        SMTPUsername:  getenv("SMTP_USERNAME", ""),
+        SMTPPassword:  getenv("SMTP_PASSWORD", ""),
+
+        TraceExporter:    getenv("TRACE_EXPORTER", "none"),
+        TraceEndpoint:    getenv("TRACE_OTLP_ENDPOINT", ""),
+        TraceSampleRatio: getenvFloat("TRACE_SAMPLE_RATIO", 1),
+
+        ReportScheduleDaily:   getschedule("REPORT_SCHEDULE_DAILY", "5 0 * * *"),
+        ReportScheduleWeekly:  getschedule("REPORT_SCHEDULE_WEEKLY", "15 0 * * 1"),
+        ReportScheduleMonthly: getschedule("REPORT_SCHEDULE_MONTHLY", "30 0 1 * *"),
+        ReportBackfillLimit:   getenvInt("REPORT_BACKFILL_LIMIT", 31),
+
+        ViewDedupWindow:      getenvDuration("VIEW_DEDUP_WINDOW", 30*time.Minute),
+        ViewLogBatchSize:     getenvInt("VIEW_LOG_BATCH_SIZE", 200),
+        ViewLogFlushInterval: getenvDuration("VIEW_LOG_FLUSH_INTERVAL", 5*time.Second),
     }
 }
-
@@ -0,0 +1,215 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+	gormlogger "gorm.io/gorm/logger"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+type ctxKey struct{}
+
+// New builds the process-wide JSON logger at the given level (debug, info,
+// warn or error) and installs it as the slog and log package default.
+func New(level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: lvl}))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, ctxKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, if any.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+	return id
+}
+
+type identityKey struct{}
+
+// Identity is the authenticated user of a request and, while an admin is
+// impersonating them, the admin.
+type Identity struct {
+	UserID         uint
+	ImpersonatorID uint
+}
+
+// WithIdentity returns a copy of ctx carrying the request's identity.
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFromContext returns the identity carried by ctx, if any.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// FromContext returns the default logger annotated with the request ID, the
+// user and impersonating admin, and, when ctx carries a sampled span, the
+// trace ID.
+func FromContext(ctx context.Context) *slog.Logger {
+	logger := slog.Default()
+	if id := RequestID(ctx); id != "" {
+		logger = logger.With("request_id", id)
+	}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		logger = logger.With("user_id", identity.UserID)
+		if identity.ImpersonatorID != 0 {
+			logger = logger.With("impersonator_id", identity.ImpersonatorID)
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.HasTraceID() {
+		logger = logger.With("trace_id", sc.TraceID().String())
+	}
+	return logger
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Middleware assigns every request an ID, taken from an incoming
+// X-Request-ID header when it looks sane or generated otherwise. The ID is
+// echoed in the response header, stored in the request context, added to
+// JSON error bodies and included in the access log line.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > 128 || strings.ContainsAny(id, "\r\n\"") {
+			id = newRequestID()
+		}
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Writer = &errorBodyWriter{ResponseWriter: c.Writer, requestID: id}
+
+		c.Next()
+
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", c.FullPath(),
+			"status", c.Writer.Status(),
+			"latency_ms", float64(time.Since(start).Microseconds()) / 1000,
+			"client_ip", c.ClientIP(),
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "errors", c.Errors.String())
+		}
+		logger := FromContext(c.Request.Context())
+		switch {
+		case c.Writer.Status() >= 500:
+			logger.Error("request", attrs...)
+		case c.Writer.Status() >= 400:
+			logger.Warn("request", attrs...)
+		default:
+			logger.Info("request", attrs...)
+		}
+	}
+}
+
+// errorBodyWriter injects "request_id" into JSON object bodies of error
+// responses so clients can quote it when reporting a problem.
+type errorBodyWriter struct {
+	gin.ResponseWriter
+	requestID string
+}
+
+func (w *errorBodyWriter) Write(b []byte) (int, error) {
+	if w.Status() < 400 || !strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		return w.ResponseWriter.Write(b)
+	}
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) < 2 || trimmed[0] != '{' {
+		return w.ResponseWriter.Write(b)
+	}
+	id, _ := json.Marshal(w.requestID)
+	field := append([]byte(`"request_id":`), id...)
+	if !bytes.Equal(bytes.TrimSpace(trimmed[1:]), []byte("}")) {
+		field = append(field, ',')
+	}
+	out := append([]byte{'{'}, append(field, trimmed[1:]...)...)
+	if _, err := w.ResponseWriter.Write(out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// GormLogger sends GORM's logs through slog, tagging each statement with
+// the request ID of the context it ran under. Every statement is logged at
+// debug level, slow ones at warn and failures at error.
+type GormLogger struct {
+	SlowThreshold time.Duration
+	level         gormlogger.LogLevel
+}
+
+func NewGormLogger() *GormLogger {
+	return &GormLogger{SlowThreshold: 200 * time.Millisecond, level: gormlogger.Info}
+}
+
+func (l *GormLogger) LogMode(level gormlogger.LogLevel) gormlogger.Interface {
+	clone := *l
+	clone.level = level
+	return &clone
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Info {
+		FromContext(ctx).Info(msg, "args", args)
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Warn {
+		FromContext(ctx).Warn(msg, "args", args)
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, args ...interface{}) {
+	if l.level >= gormlogger.Error {
+		FromContext(ctx).Error(msg, "args", args)
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= gormlogger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	logger := FromContext(ctx)
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= gormlogger.Error:
+		sql, rows := fc()
+		logger.Error("query failed", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds(), "error", err)
+	case elapsed > l.SlowThreshold && l.SlowThreshold > 0 && l.level >= gormlogger.Warn:
+		sql, rows := fc()
+		logger.Warn("slow query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	case logger.Enabled(ctx, slog.LevelDebug):
+		sql, rows := fc()
+		logger.Debug("query", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds())
+	}
+}
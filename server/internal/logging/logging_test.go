@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// captureLogs sends the default logger to a buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+// logLines decodes every JSON log line in buf.
+func logLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+	var lines []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("decode log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	return lines
+}
+
+func TestMiddlewareRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"incoming ID kept", "abc-123", true},
+		{"missing ID generated", "", false},
+		{"ID with quotes replaced", `bad"id`, false},
+		{"overlong ID replaced", strings.Repeat("a", 129), false},
+	}
+	for _, tt := range tests {
+		buf := captureLogs(t)
+		var fromContext string
+		router := gin.New()
+		router.Use(Middleware())
+		router.GET("/ping", func(c *gin.Context) {
+			fromContext = RequestID(c.Request.Context())
+			c.JSON(http.StatusOK, gin.H{"ok": true})
+		})
+		req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+		if tt.incoming != "" {
+			req.Header.Set(RequestIDHeader, tt.incoming)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		if tt.keep && id != tt.incoming {
+			t.Errorf("%s: response ID = %q, want %q", tt.name, id, tt.incoming)
+		}
+		if !tt.keep && (len(id) != 32 || id == tt.incoming) {
+			t.Errorf("%s: response ID = %q, want a generated ID", tt.name, id)
+		}
+		if fromContext != id {
+			t.Errorf("%s: context ID = %q, want %q", tt.name, fromContext, id)
+		}
+		lines := logLines(t, buf)
+		if len(lines) != 1 || lines[0]["request_id"] != id {
+			t.Errorf("%s: access log = %v, want one line with request_id %q", tt.name, lines, id)
+		}
+	}
+}
+
+func TestMiddlewareErrorBody(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name   string
+		status int
+		body   gin.H
+		want   string
+	}{
+		{"error body tagged", http.StatusNotFound, gin.H{"error": "missing"}, `{"request_id":"req-1","error":"missing"}`},
+		{"empty error body tagged", http.StatusBadRequest, gin.H{}, `{"request_id":"req-1"}`},
+		{"success body untouched", http.StatusOK, gin.H{"ok": true}, `{"ok":true}`},
+	}
+	for _, tt := range tests {
+		captureLogs(t)
+		router := gin.New()
+		router.Use(Middleware())
+		router.GET("/thing", func(c *gin.Context) { c.JSON(tt.status, tt.body) })
+		req := httptest.NewRequest(http.MethodGet, "/thing", nil)
+		req.Header.Set(RequestIDHeader, "req-1")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("%s: body = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	buf := captureLogs(t)
+	ctx := WithIdentity(WithRequestID(context.Background(), "req-2"), Identity{UserID: 5, ImpersonatorID: 1})
+	FromContext(ctx).Info("hello")
+	FromContext(context.Background()).Info("bare")
+
+	lines := logLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("%d log lines, want 2", len(lines))
+	}
+	if lines[0]["request_id"] != "req-2" || lines[0]["user_id"] != float64(5) || lines[0]["impersonator_id"] != float64(1) {
+		t.Errorf("annotated line = %v", lines[0])
+	}
+	for _, key := range []string{"request_id", "user_id", "impersonator_id"} {
+		if _, ok := lines[1][key]; ok {
+			t.Errorf("bare line has %s: %v", key, lines[1])
+		}
+	}
+}
+
+func TestGormLoggerTagsStatements(t *testing.T) {
+	buf := captureLogs(t)
+	ctx := WithRequestID(context.Background(), "req-3")
+	NewGormLogger().Trace(ctx, time.Now(), func() (string, int64) { return "SELECT 1", 1 }, nil)
+
+	lines := logLines(t, buf)
+	if len(lines) != 1 || lines[0]["request_id"] != "req-3" || lines[0]["sql"] != "SELECT 1" {
+		t.Errorf("statement log = %v, want SELECT 1 tagged with req-3", lines)
+	}
+}
@@ -12,22 +12,72 @@ const (
     RoleCSRRep   UserRole = "csr_rep"
     RolePIN      UserRole = "pin"
     RolePlatform UserRole = "platform"
+    // RoleCompanyAdmin manages the CSR reps of a single company.
+    RoleCompanyAdmin UserRole = "company_admin"
+    // RoleSuperAdmin administers every tenant and sees cross-tenant reports.
+    RoleSuperAdmin UserRole = "super_admin"
 )
 
+// DefaultTenantID is the tenant that existing rows and requests without a
+// tenant hint belong to.
+const DefaultTenantID uint = 1
+
+// Tenant is a regional chapter. Every other table carries a tenant_id and
+// chapters never see each other's data.
+type Tenant struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    Slug     string `gorm:"type:varchar(63);uniqueIndex;not null" json:"slug"`
+    Name     string `gorm:"type:varchar(255);not null" json:"name"`
+    IsActive bool   `gorm:"default:true" json:"is_active"`
+}
+
+type TenantRequest struct {
+    Slug     string `json:"slug" binding:"required"`
+    Name     string `json:"name" binding:"required"`
+    IsActive *bool  `json:"is_active"`
+}
+
+// TenantStats is one row of the cross-tenant report.
+type TenantStats struct {
+    TenantID         uint    `json:"tenant_id"`
+    Slug             string  `json:"slug"`
+    Name             string  `json:"name"`
+    Users            int64   `json:"users"`
+    Companies        int64   `json:"companies"`
+    Requests         int64   `json:"requests"`
+    Matches          int64   `json:"matches"`
+    CompletedMatches int64   `json:"completed_matches"`
+    VolunteerHours   float64 `json:"volunteer_hours"`
+}
+
 type User struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index;uniqueIndex:idx_users_tenant_username,priority:1;uniqueIndex:idx_users_tenant_email,priority:1" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
-    Username string   `gorm:"type:varchar(100);unique;not null" json:"username"`
-    Email    string   `gorm:"type:varchar(255);unique;not null" json:"email"`
+    // Usernames and emails are unique within a tenant.
+    Username string   `gorm:"type:varchar(100);not null;uniqueIndex:idx_users_tenant_username,priority:2" json:"username"`
+    Email    string   `gorm:"type:varchar(255);not null;uniqueIndex:idx_users_tenant_email,priority:2" json:"email"`
     Password string   `gorm:"type:varchar(255);not null" json:"-"`
     Role     UserRole `gorm:"type:varchar(50);not null" json:"role"`
     IsActive bool     `gorm:"default:true" json:"is_active"`
+    FailedLoginAttempts int        `gorm:"default:0" json:"-"`
+    LastFailedLoginAt   *time.Time `json:"-"`
+    LockedUntil         *time.Time `json:"locked_until,omitempty"`
+    EmailVerifiedAt     *time.Time `json:"email_verified_at"`
+    TOTPSecret          string     `gorm:"type:text" json:"-"`
+    TOTPLastStep        int64      `gorm:"default:0" json:"-"`
+    TwoFactorEnabledAt  *time.Time `json:"two_factor_enabled_at"`
+    // Sessions and tokens issued before this time are no longer accepted.
+    SessionsRevokedAt *time.Time `json:"sessions_revoked_at,omitempty"`
 }
 
 type PIN struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -45,6 +95,7 @@ type PIN struct {
 
 type CSRRep struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -59,8 +110,24 @@ type CSRRep struct {
     Position   string `gorm:"type:varchar(100)" json:"position"`
 }
 
+// CompanyAdmin attaches a company_admin user to the company they manage.
+type CompanyAdmin struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+    UserID    uint    `gorm:"not null;uniqueIndex" json:"user_id"`
+    User      User    `gorm:"foreignKey:UserID" json:"user"`
+    CompanyID uint    `gorm:"not null;index" json:"company_id"`
+    Company   Company `gorm:"foreignKey:CompanyID" json:"company"`
+    FirstName string  `gorm:"type:varchar(100);not null" json:"first_name"`
+    LastName  string  `gorm:"type:varchar(100)" json:"last_name"`
+}
+
 type Company struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -71,20 +138,105 @@ type Company struct {
     Email       string `gorm:"type:varchar(255)" json:"email"`
     Website     string `gorm:"type:varchar(255)" json:"website"`
     Description string `gorm:"type:text" json:"description"`
+    // Only verified companies accept new CSR reps.
+    VerificationStatus CompanyStatus `gorm:"type:varchar(20);not null;default:'pending';index" json:"verification_status"`
+    VerificationNotes  string        `gorm:"type:text" json:"verification_notes"`
+    ReviewedByID       *uint         `json:"reviewed_by_id,omitempty"`
+    ReviewedAt         *time.Time    `json:"reviewed_at,omitempty"`
+    ArchivedAt         *time.Time    `gorm:"index" json:"archived_at,omitempty"`
+}
+
+type CompanyStatus string
+
+const (
+    CompanyPending   CompanyStatus = "pending"
+    CompanyVerified  CompanyStatus = "verified"
+    CompanySuspended CompanyStatus = "suspended"
+)
+
+type UpdateCompanyRequest struct {
+    Name        string `json:"name" binding:"required"`
+    Industry    string `json:"industry"`
+    Address     string `json:"address"`
+    Phone       string `json:"phone"`
+    Email       string `json:"email"`
+    Website     string `json:"website"`
+    Description string `json:"description"`
+}
+
+type CompanyVerificationRequest struct {
+    Status CompanyStatus `json:"status" binding:"required,oneof=pending verified suspended"`
+    Notes  string        `json:"notes"`
 }
 
 type ServiceCategory struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
     Name        string `gorm:"type:varchar(255);not null" json:"name"`
     Description string `gorm:"type:text" json:"description"`
     IsActive    bool   `gorm:"default:true" json:"is_active"`
+    // Categories nest, e.g. Transport > Medical appointments. Searching by a
+    // category also matches requests in its descendants.
+    ParentID     *uint                        `gorm:"index" json:"parent_id"`
+    Icon         string                       `gorm:"type:varchar(100)" json:"icon"`
+    Color        string                       `gorm:"type:varchar(7)" json:"color"`
+    SortOrder    int                          `gorm:"not null;default:0" json:"sort_order"`
+    Translations []ServiceCategoryTranslation `gorm:"foreignKey:CategoryID" json:"translations,omitempty"`
+    // Archived categories take no new requests but stay readable on existing
+    // ones. A category merged into another is archived with MergedIntoID set.
+    ArchivedAt   *time.Time                   `gorm:"index" json:"archived_at"`
+    MergedIntoID *uint                        `json:"merged_into_id"`
+}
+
+// ServiceCategoryTranslation holds a category's name and description in one
+// locale. The category's own Name and Description are the default locale.
+type ServiceCategoryTranslation struct {
+    ID          uint      `gorm:"primaryKey" json:"id"`
+    TenantID    uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt   time.Time `json:"created_at"`
+    UpdatedAt   time.Time `json:"updated_at"`
+    CategoryID  uint      `gorm:"not null;uniqueIndex:idx_category_translation_locale" json:"category_id"`
+    Locale      string    `gorm:"type:varchar(35);not null;uniqueIndex:idx_category_translation_locale" json:"locale"`
+    Name        string    `gorm:"type:varchar(255);not null" json:"name"`
+    Description string    `gorm:"type:text" json:"description"`
+}
+
+type CategoryTranslationRequest struct {
+    Name        string `json:"name" binding:"required"`
+    Description string `json:"description"`
+}
+
+type MergeCategoryRequest struct {
+    TargetID uint `json:"target_id" binding:"required"`
+}
+
+type CategoryMergeResult struct {
+    Source             ServiceCategory `json:"source"`
+    Target             ServiceCategory `json:"target"`
+    RequestsMoved      int64           `json:"requests_moved"`
+    SubcategoriesMoved int64           `json:"subcategories_moved"`
+}
+
+// LocalizedCategory is a category in the caller's language, with its active
+// subcategories.
+type LocalizedCategory struct {
+    ID          uint                `json:"id"`
+    ParentID    *uint               `json:"parent_id"`
+    Locale      string              `json:"locale"`
+    Name        string              `json:"name"`
+    Description string              `json:"description"`
+    Icon        string              `json:"icon"`
+    Color       string              `json:"color"`
+    SortOrder   int                 `json:"sort_order"`
+    Children    []LocalizedCategory `json:"children"`
 }
 
 type PINRequest struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -100,11 +252,33 @@ type PINRequest struct {
     Location        string          `gorm:"type:varchar(255)" json:"location"`
     SpecialNotes    string          `gorm:"type:text" json:"special_notes"`
     ViewCount       int             `gorm:"default:0" json:"view_count"`
+    UniqueViewCount int             `gorm:"default:0" json:"unique_view_count"`
     ShortlistCount  int             `gorm:"default:0" json:"shortlist_count"`
+    // Only approved requests are visible to CSR reps. ModerationFlags lists
+    // what the pre-screen found, comma separated; ModerationNote is the
+    // moderator's reason for rejecting or asking for changes.
+    ModerationStatus string     `gorm:"type:varchar(30);not null;default:'approved';index" json:"moderation_status"`
+    ModerationFlags  string     `gorm:"type:text" json:"moderation_flags,omitempty"`
+    ModerationNote   string     `gorm:"type:text" json:"moderation_note,omitempty"`
+    ModeratedByID    *uint      `json:"moderated_by_id,omitempty"`
+    ModeratedAt      *time.Time `json:"moderated_at,omitempty"`
+}
+
+const (
+    ModerationApproved         = "approved"
+    ModerationPending          = "pending_review"
+    ModerationRejected         = "rejected"
+    ModerationChangesRequested = "changes_requested"
+)
+
+type ModerateRequestRequest struct {
+    Decision string `json:"decision" binding:"required,oneof=approve reject request_changes"`
+    Reason   string `json:"reason"`
 }
 
 type Shortlist struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -118,6 +292,7 @@ type Shortlist struct {
 
 type Match struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
@@ -138,10 +313,11 @@ type Match struct {
 
 type ViewLog struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
-    CSRRepID  uint       `gorm:"not null" json:"csr_rep_id"`
+    CSRRepID  uint       `gorm:"not null;index:idx_view_log_request_rep,priority:2" json:"csr_rep_id"`
     CSRRep    CSRRep     `gorm:"foreignKey:CSRRepID" json:"csr_rep"`
-    RequestID uint       `gorm:"not null" json:"request_id"`
+    RequestID uint       `gorm:"not null;index:idx_view_log_request_rep,priority:1" json:"request_id"`
     Request   PINRequest `gorm:"foreignKey:RequestID" json:"request"`
     IPAddress string     `gorm:"type:varchar(45)" json:"ip_address"`
     UserAgent string     `gorm:"type:text" json:"user_agent"`
@@ -149,11 +325,12 @@ type ViewLog struct {
 
 type Report struct {
     ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index;uniqueIndex:idx_report_period,priority:1" json:"tenant_id"`
     CreatedAt time.Time `json:"created_at"`
     UpdatedAt time.Time `json:"updated_at"`
     DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
-    ReportType string    `gorm:"type:varchar(50);not null" json:"report_type"`
-    Period     string    `gorm:"type:varchar(20);not null" json:"period"`
+    ReportType string    `gorm:"type:varchar(50);not null;uniqueIndex:idx_report_period,where:deleted_at IS NULL" json:"report_type"`
+    Period     string    `gorm:"type:varchar(20);not null;uniqueIndex:idx_report_period" json:"period"`
     Data       string    `gorm:"type:jsonb" json:"data"`
     GeneratedAt time.Time `json:"generated_at"`
 }
@@ -166,10 +343,14 @@ type RequestFilter struct {
     EndDate    *time.Time `json:"end_date,omitempty"`
     Location   *string    `json:"location,omitempty"`
     Search     *string    `json:"search,omitempty"`
+    // ViewerID hides the requests of PINs the viewing user has a block with.
+    // Unset, the searching user is taken from the request context.
+    ViewerID *uint `json:"-"`
 }
 
 type MatchFilter struct {
     CSRRepID   *uint      `json:"csr_rep_id,omitempty"`
+    CompanyID  *uint      `json:"company_id,omitempty"`
     PINID      *uint      `json:"pin_id,omitempty"`
     CategoryID *uint      `json:"category_id,omitempty"`
     Status     *string    `json:"status,omitempty"`
@@ -231,3 +412,540 @@ type CreateMatchRequest struct {
     Notes     string     `json:"notes"`
 }
 
+
+type Conversation struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+    MatchID       uint       `gorm:"not null;uniqueIndex" json:"match_id"`
+    Match         Match      `gorm:"foreignKey:MatchID" json:"-"`
+    LastMessageAt *time.Time `json:"last_message_at"`
+    IsLocked      bool       `gorm:"default:false" json:"is_locked"`
+}
+
+type Message struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+    ConversationID uint                `gorm:"not null;index" json:"conversation_id"`
+    SenderID       uint                `gorm:"not null" json:"sender_id"`
+    Sender         User                `gorm:"foreignKey:SenderID" json:"sender"`
+    Body           string              `gorm:"type:text;not null" json:"body"`
+    Attachments    []MessageAttachment `gorm:"foreignKey:MessageID" json:"attachments"`
+    Receipts       []MessageReceipt    `gorm:"foreignKey:MessageID" json:"receipts"`
+    IsFlagged      bool                `gorm:"default:false;index" json:"is_flagged"`
+    FlagReason     string              `gorm:"type:text" json:"flag_reason,omitempty"`
+    FlaggedByID    *uint               `json:"flagged_by_id,omitempty"`
+    IsHidden       bool                `gorm:"default:false" json:"is_hidden"`
+    ModeratedByID  *uint               `json:"moderated_by_id,omitempty"`
+    ModeratedAt    *time.Time          `json:"moderated_at,omitempty"`
+}
+
+// MessageAttachment references a file stored outside the database; only the
+// location and metadata are kept here.
+type MessageAttachment struct {
+    ID          uint      `gorm:"primaryKey" json:"id"`
+    TenantID    uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt   time.Time `json:"created_at"`
+    MessageID   uint      `gorm:"not null;index" json:"message_id"`
+    FileName    string    `gorm:"type:varchar(255);not null" json:"file_name"`
+    URL         string    `gorm:"type:text;not null" json:"url"`
+    ContentType string    `gorm:"type:varchar(100)" json:"content_type"`
+    SizeBytes   int64     `json:"size_bytes"`
+}
+
+type MessageReceipt struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    MessageID uint      `gorm:"not null;uniqueIndex:idx_receipt_message_user" json:"message_id"`
+    UserID    uint      `gorm:"not null;uniqueIndex:idx_receipt_message_user" json:"user_id"`
+    ReadAt    time.Time `gorm:"not null" json:"read_at"`
+}
+
+type AttachmentInput struct {
+    FileName    string `json:"file_name" binding:"required"`
+    URL         string `json:"url" binding:"required,url"`
+    ContentType string `json:"content_type"`
+    SizeBytes   int64  `json:"size_bytes"`
+}
+
+type SendMessageRequest struct {
+    Body        string            `json:"body" binding:"required,max=4000"`
+    Attachments []AttachmentInput `json:"attachments" binding:"max=5,dive"`
+}
+
+type FlagMessageRequest struct {
+    Reason string `json:"reason" binding:"required"`
+}
+
+type ModerateMessageRequest struct {
+    Hidden bool `json:"hidden"`
+}
+
+type ConversationResponse struct {
+    Conversation Conversation `json:"conversation"`
+    Messages     []Message    `json:"messages"`
+    UnreadCount  int64        `json:"unread_count"`
+}
+
+// TimesheetEntry records one check-in/check-out session served by a CSR rep on
+// a match. Hours only count towards totals once a PIN or coordinator confirms.
+type TimesheetEntry struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    DeletedAt gorm.DeletedAt `gorm:"index" json:"deleted_at,omitempty"`
+    // A rep has at most one open session per match.
+    MatchID       uint       `gorm:"not null;index;uniqueIndex:idx_timesheet_open_session,where:check_out_at IS NULL AND deleted_at IS NULL" json:"match_id"`
+    CSRRepID      uint       `gorm:"not null;index;uniqueIndex:idx_timesheet_open_session" json:"csr_rep_id"`
+    CheckInAt     time.Time  `gorm:"not null" json:"check_in_at"`
+    CheckOutAt    *time.Time `json:"check_out_at"`
+    Hours         float64    `gorm:"type:numeric(6,2);default:0" json:"hours"`
+    CheckInLat    *float64   `json:"check_in_lat,omitempty"`
+    CheckInLng    *float64   `json:"check_in_lng,omitempty"`
+    CheckOutLat   *float64   `json:"check_out_lat,omitempty"`
+    CheckOutLng   *float64   `json:"check_out_lng,omitempty"`
+    Status        string     `gorm:"type:varchar(50);default:'open';index" json:"status"`
+    ConfirmedByID *uint      `json:"confirmed_by_id,omitempty"`
+    ConfirmedAt   *time.Time `json:"confirmed_at,omitempty"`
+    Notes         string     `gorm:"type:text" json:"notes"`
+    ReviewNote    string     `gorm:"type:text" json:"review_note"`
+}
+
+type CheckInRequest struct {
+    Latitude  *float64 `json:"latitude" binding:"omitempty,min=-90,max=90"`
+    Longitude *float64 `json:"longitude" binding:"omitempty,min=-180,max=180"`
+    Notes     string   `json:"notes"`
+}
+
+type ConfirmHoursRequest struct {
+    Confirmed bool   `json:"confirmed"`
+    Note      string `json:"note"`
+}
+
+type HoursFilter struct {
+    CSRRepID  *uint      `json:"csr_rep_id,omitempty"`
+    CompanyID *uint      `json:"company_id,omitempty"`
+    StartDate *time.Time `json:"start_date,omitempty"`
+    EndDate   *time.Time `json:"end_date,omitempty"`
+}
+
+type HoursTotal struct {
+    ID             uint    `json:"id"`
+    Name           string  `json:"name"`
+    ConfirmedHours float64 `json:"confirmed_hours"`
+    PendingHours   float64 `json:"pending_hours"`
+    Sessions       int64   `json:"sessions"`
+}
+
+type CategoryImpact struct {
+    CategoryID       uint    `json:"category_id"`
+    CategoryName     string  `json:"category_name"`
+    MatchesCompleted int64   `json:"matches_completed"`
+    VolunteerHours   float64 `json:"volunteer_hours"`
+}
+
+type ImpactMetrics struct {
+    VolunteerHours      float64          `json:"volunteer_hours"`
+    MatchesCompleted    int64            `json:"matches_completed"`
+    BeneficiariesServed int64            `json:"beneficiaries_served"`
+    ActiveVolunteers    int64            `json:"active_volunteers"`
+    AverageRating       *float64         `json:"average_rating"`
+    ByCategory          []CategoryImpact `json:"by_category"`
+}
+
+// ImpactTrend holds the percentage change of each headline metric against the
+// same period one year earlier; nil when the previous value was zero.
+type ImpactTrend struct {
+    VolunteerHours      *float64 `json:"volunteer_hours"`
+    MatchesCompleted    *float64 `json:"matches_completed"`
+    BeneficiariesServed *float64 `json:"beneficiaries_served"`
+    AverageRating       *float64 `json:"average_rating"`
+}
+
+type CompanyImpactReport struct {
+    Company      Company       `json:"company"`
+    PeriodStart  time.Time     `json:"period_start"`
+    PeriodEnd    time.Time     `json:"period_end"`
+    Current      ImpactMetrics `json:"current"`
+    PreviousYear ImpactMetrics `json:"previous_year"`
+    Trend        ImpactTrend   `json:"trend"`
+    GeneratedAt  time.Time     `json:"generated_at"`
+}
+
+// FunnelRow is one bucket of the view -> shortlist -> match -> completed
+// funnel. Rates are fractions of Requests; median durations are in hours and
+// nil when no request in the bucket reached that stage.
+type FunnelRow struct {
+    Key                    string   `json:"key"`
+    Label                  string   `json:"label"`
+    Requests               int64    `json:"requests"`
+    Viewed                 int64    `json:"viewed"`
+    Shortlisted            int64    `json:"shortlisted"`
+    Matched                int64    `json:"matched"`
+    Completed              int64    `json:"completed"`
+    ViewRate               float64  `json:"view_rate" gorm:"-"`
+    ShortlistRate          float64  `json:"shortlist_rate" gorm:"-"`
+    MatchRate              float64  `json:"match_rate" gorm:"-"`
+    CompletionRate         float64  `json:"completion_rate" gorm:"-"`
+    MedianHoursToFirstView *float64 `json:"median_hours_to_first_view"`
+    MedianHoursToMatch     *float64 `json:"median_hours_to_match"`
+}
+
+type FunnelFilter struct {
+    GroupBy    string    `json:"group_by"`
+    Bucket     string    `json:"bucket,omitempty"`
+    CategoryID *uint     `json:"category_id,omitempty"`
+    StartDate  time.Time `json:"start_date"`
+    EndDate    time.Time `json:"end_date"`
+}
+
+type FunnelResponse struct {
+    Filter  FunnelFilter `json:"filter"`
+    Overall FunnelRow    `json:"overall"`
+    Rows    []FunnelRow  `json:"rows"`
+}
+
+// SchemaMigration records each schema version AutoMigrate has applied.
+type SchemaMigration struct {
+    Version   int       `gorm:"primaryKey;autoIncrement:false" json:"version"`
+    AppliedAt time.Time `json:"applied_at"`
+}
+
+// RateLimitBucket is the Postgres-backed token bucket state used when rate
+// limits must be shared between several API instances.
+type RateLimitBucket struct {
+    Key       string    `gorm:"type:varchar(255);primaryKey" json:"key"`
+    Tokens    float64   `gorm:"not null" json:"tokens"`
+    Allowed   bool      `gorm:"not null" json:"allowed"`
+    UpdatedAt time.Time `gorm:"not null;index" json:"updated_at"`
+}
+
+const (
+    TokenPurposePasswordReset     = "password_reset"
+    TokenPurposeEmailVerification = "email_verification"
+    TokenPurposeTwoFactorLogin    = "two_factor_login"
+    TokenPurposeSession           = "session"
+    TokenPurposeImpersonation     = "impersonation"
+    TokenPurposeInvitation        = "invitation"
+)
+
+// AuthToken is a single-use token sent to a user by email. Only a hash of
+// the token is stored.
+type AuthToken struct {
+    ID        uint       `gorm:"primaryKey" json:"id"`
+    TenantID  uint       `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    UserID    uint       `gorm:"not null;index" json:"user_id"`
+    Purpose   string     `gorm:"type:varchar(50);not null" json:"purpose"`
+    TokenHash string     `gorm:"type:varchar(64);not null;uniqueIndex" json:"-"`
+    Payload   string     `gorm:"type:text" json:"-"`
+    ExpiresAt time.Time  `gorm:"not null" json:"expires_at"`
+    UsedAt    *time.Time `json:"used_at"`
+}
+
+type ForgotPasswordRequest struct {
+    Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+    Token    string `json:"token" binding:"required"`
+    Password string `json:"password" binding:"required,min=6"`
+}
+
+type VerifyEmailRequest struct {
+    Token string `json:"token" binding:"required"`
+}
+
+type RecoveryCode struct {
+    ID        uint       `gorm:"primaryKey" json:"id"`
+    TenantID  uint       `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time  `json:"created_at"`
+    UserID    uint       `gorm:"not null;index" json:"user_id"`
+    CodeHash  string     `gorm:"type:varchar(64);not null" json:"-"`
+    UsedAt    *time.Time `json:"used_at"`
+}
+
+// AuditLog records a security-relevant action taken by a user.
+type AuditLog struct {
+    ID         uint      `gorm:"primaryKey" json:"id"`
+    TenantID   uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt  time.Time `gorm:"index" json:"created_at"`
+    ActorID    *uint     `gorm:"index" json:"actor_id"`
+    Actor      *User     `gorm:"foreignKey:ActorID" json:"actor,omitempty"`
+    Action     string    `gorm:"type:varchar(100);not null;index" json:"action"`
+    TargetType string    `gorm:"type:varchar(50)" json:"target_type"`
+    TargetID   *uint     `json:"target_id"`
+    Details    string    `gorm:"type:jsonb" json:"details"`
+    IPAddress  string    `gorm:"type:varchar(45)" json:"ip_address"`
+    // Set when the actor was being impersonated by this admin.
+    ImpersonatorID *uint `gorm:"index" json:"impersonator_id,omitempty"`
+}
+
+// UserFilter narrows the admin user search. Query matches the username or
+// email; Status is active, inactive or locked.
+type UserFilter struct {
+    Role   *UserRole `json:"role,omitempty"`
+    Email  string    `json:"email,omitempty"`
+    Query  string    `json:"q,omitempty"`
+    Status string    `json:"status,omitempty"`
+}
+
+// UserDetail is a user with whichever profiles are linked to the account.
+type UserDetail struct {
+    User         User          `json:"user"`
+    PIN          *PIN          `json:"pin,omitempty"`
+    CSRRep       *CSRRep       `json:"csr_rep,omitempty"`
+    CompanyAdmin *CompanyAdmin `json:"company_admin,omitempty"`
+}
+
+type SetRoleRequest struct {
+    Role UserRole `json:"role" binding:"required,oneof=pin csr_rep admin platform company_admin super_admin"`
+    // Used to create the profile of a pin, csr_rep or company_admin role
+    // when the user has none.
+    CompanyID uint   `json:"company_id"`
+    FirstName string `json:"first_name"`
+    LastName  string `json:"last_name"`
+}
+
+type ImpersonateRequest struct {
+    Reason     string `json:"reason" binding:"required"`
+    AllowWrite bool   `json:"allow_write"`
+}
+
+type ImpersonationResponse struct {
+    Token     string    `json:"token"`
+    ExpiresAt time.Time `json:"expires_at"`
+    ReadOnly  bool      `json:"read_only"`
+    User      User      `json:"user"`
+}
+
+// Impersonation describes the admin behind an impersonation token.
+type Impersonation struct {
+    AdminID       uint      `json:"admin_id"`
+    AdminUsername string    `json:"admin_username"`
+    Reason        string    `json:"reason"`
+    ReadOnly      bool      `json:"read_only"`
+    ExpiresAt     time.Time `json:"expires_at"`
+}
+
+type AuditLogFilter struct {
+    ActorID    *uint      `json:"actor_id,omitempty"`
+    Action     *string    `json:"action,omitempty"`
+    TargetType *string    `json:"target_type,omitempty"`
+    TargetID   *uint      `json:"target_id,omitempty"`
+    StartDate  *time.Time `json:"start_date,omitempty"`
+    EndDate    *time.Time `json:"end_date,omitempty"`
+}
+
+// TwoFactorChallenge is returned by login instead of a token when a second
+// factor is needed. EnrollmentRequired means the account must set up 2FA
+// (via /auth/2fa/enroll) before it can finish logging in.
+type TwoFactorChallenge struct {
+    TwoFactorRequired  bool      `json:"two_factor_required"`
+    EnrollmentRequired bool      `json:"enrollment_required"`
+    ChallengeToken     string    `json:"challenge_token"`
+    ExpiresAt          time.Time `json:"expires_at"`
+}
+
+type TwoFactorEnrollment struct {
+    Secret     string `json:"secret"`
+    OTPAuthURI string `json:"otpauth_uri"`
+}
+
+type TwoFactorChallengeRequest struct {
+    ChallengeToken string `json:"challenge_token" binding:"required"`
+}
+
+type TwoFactorVerifyRequest struct {
+    ChallengeToken string `json:"challenge_token" binding:"required"`
+    Code           string `json:"code"`
+    RecoveryCode   string `json:"recovery_code"`
+}
+
+type TwoFactorCodeRequest struct {
+    Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorLoginResponse struct {
+    LoginResponse
+    RecoveryCodes []string `json:"recovery_codes,omitempty"`
+}
+
+// CompanySSOConfig holds a company's OIDC identity provider settings. Only
+// users whose email domain is listed in AllowedEmailDomains may sign in.
+type CompanySSOConfig struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    CompanyID           uint    `gorm:"not null;uniqueIndex" json:"company_id"`
+    Company             Company `gorm:"foreignKey:CompanyID" json:"-"`
+    Issuer              string  `gorm:"type:varchar(255);not null" json:"issuer"`
+    ClientID            string  `gorm:"type:varchar(255);not null" json:"client_id"`
+    ClientSecret        string  `gorm:"type:text" json:"-"`
+    AllowedEmailDomains string  `gorm:"type:text;not null" json:"allowed_email_domains"`
+    Enabled             bool    `gorm:"default:true" json:"enabled"`
+}
+
+// UserIdentity links a user to the subject of an external identity provider.
+type UserIdentity struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index;uniqueIndex:idx_user_identity_tenant_subject,priority:1" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UserID    uint      `gorm:"not null;index" json:"user_id"`
+    User      User      `gorm:"foreignKey:UserID" json:"-"`
+    Issuer    string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identity_tenant_subject,priority:2" json:"issuer"`
+    Subject   string    `gorm:"type:varchar(255);not null;uniqueIndex:idx_user_identity_tenant_subject,priority:3" json:"subject"`
+}
+
+type CompanySSORequest struct {
+    Issuer              string   `json:"issuer" binding:"required,url"`
+    ClientID            string   `json:"client_id" binding:"required"`
+    ClientSecret        string   `json:"client_secret"`
+    AllowedEmailDomains []string `json:"allowed_email_domains" binding:"required,min=1"`
+    Enabled             *bool    `json:"enabled"`
+}
+
+// APIKey lets a machine integration authenticate as a user. The key is
+// shown once at creation; only its hash is stored, and Prefix identifies it
+// in listings and logs. Scopes limit it to the listed route groups.
+type APIKey struct {
+    ID        uint      `gorm:"primaryKey" json:"id"`
+    TenantID  uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+    UserID      uint       `gorm:"not null;index" json:"user_id"`
+    User        User       `gorm:"foreignKey:UserID" json:"-"`
+    CreatedByID uint       `gorm:"not null" json:"created_by_id"`
+    Name        string     `gorm:"type:varchar(100);not null" json:"name"`
+    Prefix      string     `gorm:"type:varchar(16);not null;uniqueIndex" json:"prefix"`
+    KeyHash     string     `gorm:"type:varchar(64);not null" json:"-"`
+    Scopes      string     `gorm:"type:text;not null" json:"scopes"`
+    ExpiresAt   *time.Time `json:"expires_at"`
+    LastUsedAt  *time.Time `json:"last_used_at"`
+    LastUsedIP  string     `gorm:"type:varchar(45)" json:"last_used_ip"`
+    RevokedAt   *time.Time `json:"revoked_at"`
+}
+
+type CreateAPIKeyRequest struct {
+    UserID    uint       `json:"user_id" binding:"required"`
+    Name      string     `json:"name" binding:"required,max=100"`
+    Scopes    []string   `json:"scopes" binding:"required,min=1"`
+    ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey is returned once, when the key is issued.
+type CreatedAPIKey struct {
+    APIKey
+    Key string `json:"key"`
+}
+
+// InviteUserRequest creates an account for someone else and emails them a
+// link to choose their password.
+type InviteUserRequest struct {
+    Email      string `json:"email" binding:"required,email"`
+    FirstName  string `json:"first_name" binding:"required"`
+    LastName   string `json:"last_name"`
+    Phone      string `json:"phone"`
+    Department string `json:"department"`
+    Position   string `json:"position"`
+}
+
+type SetActiveRequest struct {
+    Active *bool `json:"active" binding:"required"`
+}
+
+type ReassignMatchRequest struct {
+    CSRRepID uint `json:"csr_rep_id" binding:"required"`
+}
+
+type CompanyDashboard struct {
+    CompanyID       uint             `json:"company_id"`
+    ActiveReps      int64            `json:"active_reps"`
+    InactiveReps    int64            `json:"inactive_reps"`
+    Shortlists      int64            `json:"shortlists"`
+    MatchesByStatus map[string]int64 `json:"matches_by_status"`
+    VolunteerHours  []HoursTotal     `json:"volunteer_hours"`
+    Impact          *ImpactMetrics   `json:"impact"`
+}
+
+// Abuse reports and blocks
+
+const (
+    AbuseTargetRequest = "request"
+    AbuseTargetMatch   = "match"
+    AbuseTargetUser    = "user"
+)
+
+const (
+    AbuseReportOpen          = "open"
+    AbuseReportInvestigating = "investigating"
+    AbuseReportResolved      = "resolved"
+    AbuseReportDismissed     = "dismissed"
+)
+
+// AbuseReport is a user's complaint about a request, a match or another
+// user. ReportedUserID is the user the complaint is about: the request's PIN,
+// the other party of the match or the user reported directly.
+type AbuseReport struct {
+    ID              uint       `gorm:"primaryKey" json:"id"`
+    TenantID        uint       `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt       time.Time  `gorm:"index" json:"created_at"`
+    UpdatedAt       time.Time  `json:"updated_at"`
+    ReporterID      uint       `gorm:"not null;index;uniqueIndex:idx_abuse_report_open,priority:1" json:"reporter_id"`
+    Reporter        User       `gorm:"foreignKey:ReporterID" json:"reporter"`
+    TargetType      string     `gorm:"type:varchar(20);not null;index:idx_abuse_report_target;uniqueIndex:idx_abuse_report_open,priority:2,where:status = 'open' OR status = 'investigating'" json:"target_type"`
+    TargetID        uint       `gorm:"not null;index:idx_abuse_report_target;uniqueIndex:idx_abuse_report_open,priority:3" json:"target_id"`
+    ReportedUserID  uint       `gorm:"not null;index" json:"reported_user_id"`
+    ReportedUser    User       `gorm:"foreignKey:ReportedUserID" json:"reported_user"`
+    Reason          string     `gorm:"type:varchar(50);not null" json:"reason"`
+    Details         string     `gorm:"type:text" json:"details"`
+    Status          string     `gorm:"type:varchar(20);not null;default:'open';index" json:"status"`
+    ResolutionNotes string     `gorm:"type:text" json:"resolution_notes"`
+    HandledByID     *uint      `json:"handled_by_id"`
+    HandledAt       *time.Time `json:"handled_at"`
+}
+
+type CreateAbuseReportRequest struct {
+    Reason  string `json:"reason" binding:"required,oneof=harassment inappropriate_behaviour no_show fraud spam safety other"`
+    Details string `json:"details"`
+}
+
+// TriageAbuseReportRequest updates a report. BlockReportedUser also blocks
+// the reported user on the reporter's behalf.
+type TriageAbuseReportRequest struct {
+    Status            string `json:"status" binding:"required,oneof=open investigating resolved dismissed"`
+    ResolutionNotes   string `json:"resolution_notes"`
+    BlockReportedUser bool   `json:"block_reported_user"`
+}
+
+type AbuseReportFilter struct {
+    Status         string `json:"status,omitempty"`
+    TargetType     string `json:"target_type,omitempty"`
+    ReportedUserID *uint  `json:"reported_user_id,omitempty"`
+}
+
+// UserBlock stops two users from interacting: the blocked CSR rep no longer
+// sees or can shortlist or match the PIN's requests, and vice versa.
+// CreatedByID is set when an admin imposed the block.
+type UserBlock struct {
+    ID          uint      `gorm:"primaryKey" json:"id"`
+    TenantID    uint      `gorm:"not null;default:1;index" json:"tenant_id"`
+    CreatedAt   time.Time `json:"created_at"`
+    BlockerID   uint      `gorm:"not null;uniqueIndex:idx_user_block" json:"blocker_id"`
+    BlockedID   uint      `gorm:"not null;uniqueIndex:idx_user_block;index" json:"blocked_id"`
+    Blocked     User      `gorm:"foreignKey:BlockedID" json:"blocked"`
+    Reason      string    `gorm:"type:text" json:"reason"`
+    CreatedByID *uint     `json:"created_by_id,omitempty"`
+}
+
+type BlockUserRequest struct {
+    UserID uint   `json:"user_id" binding:"required"`
+    Reason string `json:"reason"`
+}
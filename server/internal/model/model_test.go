@@ -0,0 +1,82 @@
+package model
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm/schema"
+)
+
+func TestAPIKeyJSONOmitsUser(t *testing.T) {
+	key := APIKey{ID: 7, UserID: 3, User: User{ID: 3, Username: "integration", Email: "ops@example.org"}, KeyHash: "secret-hash"}
+	data, err := json.Marshal(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, leaked := range []string{`"user":`, "ops@example.org", "secret-hash"} {
+		if strings.Contains(string(data), leaked) {
+			t.Errorf("API key JSON contains %s: %s", leaked, data)
+		}
+	}
+	if !strings.Contains(string(data), `"user_id":3`) {
+		t.Errorf("API key JSON lacks user_id: %s", data)
+	}
+}
+
+func TestAccountUniquenessIsPerTenant(t *testing.T) {
+	tests := []struct {
+		model   interface{}
+		index   string
+		columns []string
+	}{
+		{&User{}, "idx_users_tenant_username", []string{"tenant_id", "username"}},
+		{&User{}, "idx_users_tenant_email", []string{"tenant_id", "email"}},
+		{&UserIdentity{}, "idx_user_identity_tenant_subject", []string{"tenant_id", "issuer", "subject"}},
+	}
+	for _, tt := range tests {
+		s, err := schema.Parse(tt.model, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		index := s.LookIndex(tt.index)
+		if index == nil || index.Class != "UNIQUE" {
+			t.Errorf("%s: no unique index %s", s.Table, tt.index)
+			continue
+		}
+		var columns []string
+		for _, option := range index.Fields {
+			columns = append(columns, option.DBName)
+		}
+		if strings.Join(columns, ",") != strings.Join(tt.columns, ",") {
+			t.Errorf("%s: %s covers %v, want %v", s.Table, tt.index, columns, tt.columns)
+		}
+		for _, field := range s.Fields {
+			if field.Unique {
+				t.Errorf("%s.%s is unique across tenants", s.Table, field.DBName)
+			}
+		}
+	}
+}
+
+func TestAbuseReportOpenIndex(t *testing.T) {
+	s, err := schema.Parse(&AbuseReport{}, &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := s.LookIndex("idx_abuse_report_open")
+	if index == nil || index.Class != "UNIQUE" {
+		t.Fatal("no unique index idx_abuse_report_open")
+	}
+	var columns []string
+	for _, option := range index.Fields {
+		columns = append(columns, option.DBName)
+	}
+	if got := strings.Join(columns, ","); got != "reporter_id,target_type,target_id" {
+		t.Errorf("idx_abuse_report_open covers %s", got)
+	}
+	if index.Where != "status = 'open' OR status = 'investigating'" {
+		t.Errorf("idx_abuse_report_open applies where %q", index.Where)
+	}
+}
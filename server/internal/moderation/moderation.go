@@ -0,0 +1,150 @@
+// Package moderation pre-screens user-written text before it is published.
+package moderation
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	EmailPattern = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+
+	// phoneCandidate matches digits shaped like a phone number: an optional
+	// country code and bracketed area code, then two to five groups joined by
+	// single spaces, dots or dashes, or one unbroken run of digits.
+	phoneCandidate = regexp.MustCompile(`(?:\+\d{1,3}[\s.\-]?)?(?:\(\d{1,5}\)[\s.\-]?)?\d{2,5}(?:[\s.\-]\d{2,8}){1,4}|\+?\d{7,15}`)
+	// notPhone matches candidates that are, or start with, a date, and
+	// those that are a time or year range.
+	notPhone = regexp.MustCompile(`^(?:(?:\d{4}[./\-]\d{1,2}[./\-]\d{1,2}|\d{1,2}[./\-]\d{1,2}[./\-]\d{4})(?:\s|$)|(?:\d{1,2}[.:]\d{2}\s?-\s?\d{1,2}[.:]\d{2}|(?:19|20)\d{2}\s?-\s?(?:19|20)\d{2})$)`)
+)
+
+// findPhoneNumbers returns the positions of phone numbers in text. A
+// candidate counts if it has 7 to 15 digits, is not part of a longer word or
+// number and is not a date, time or year range.
+func findPhoneNumbers(text string) [][]int {
+	var found [][]int
+	for _, loc := range phoneCandidate.FindAllStringIndex(text, -1) {
+		candidate := text[loc[0]:loc[1]]
+		if loc[0] > 0 && isWordByte(text[loc[0]-1]) || loc[1] < len(text) && isWordByte(text[loc[1]]) {
+			continue
+		}
+		digits := 0
+		for i := 0; i < len(candidate); i++ {
+			if candidate[i] >= '0' && candidate[i] <= '9' {
+				digits++
+			}
+		}
+		if digits < 7 || digits > 15 || notPhone.MatchString(candidate) {
+			continue
+		}
+		found = append(found, loc)
+	}
+	return found
+}
+
+func isWordByte(b byte) bool {
+	return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+// ContainsPhoneNumber reports whether text contains a phone number.
+func ContainsPhoneNumber(text string) bool {
+	return len(findPhoneNumbers(text)) > 0
+}
+
+// ReplacePhoneNumbers replaces every phone number in text with repl.
+func ReplacePhoneNumbers(text, repl string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range findPhoneNumbers(text) {
+		b.WriteString(text[last:loc[0]])
+		b.WriteString(repl)
+		last = loc[1]
+	}
+	b.WriteString(text[last:])
+	return b.String()
+}
+
+// Modes of request moderation: off publishes everything, flagged holds
+// requests the pre-screen flags for review and all holds every new or
+// edited request.
+const (
+	ModeOff     = "off"
+	ModeFlagged = "flagged"
+	ModeAll     = "all"
+)
+
+// Flags reported by Screen. Prohibited terms are reported as
+// "prohibited_term:<term>".
+const (
+	FlagEmail          = "email"
+	FlagPhone          = "phone_number"
+	FlagProhibitedTerm = "prohibited_term"
+)
+
+type Screener struct {
+	mode  string
+	terms []*regexp.Regexp
+	words []string
+}
+
+// NewScreener returns a screener for mode that flags contact details and
+// the given prohibited terms, which match whole words regardless of case.
+func NewScreener(mode string, terms []string) (*Screener, error) {
+	switch mode {
+	case ModeOff, ModeFlagged, ModeAll:
+	default:
+		return nil, fmt.Errorf("unknown moderation mode %q", mode)
+	}
+	s := &Screener{mode: mode}
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if term == "" {
+			continue
+		}
+		s.terms = append(s.terms, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(term)+`\b`))
+		s.words = append(s.words, term)
+	}
+	return s, nil
+}
+
+// Enabled reports whether requests are screened at all.
+func (s *Screener) Enabled() bool { return s != nil && s.mode != ModeOff }
+
+// Screen returns the flags raised by texts, without duplicates.
+func (s *Screener) Screen(texts ...string) []string {
+	var flags []string
+	seen := map[string]bool{}
+	flag := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			flags = append(flags, f)
+		}
+	}
+	for _, text := range texts {
+		if EmailPattern.MatchString(text) {
+			flag(FlagEmail)
+		}
+		if ContainsPhoneNumber(text) {
+			flag(FlagPhone)
+		}
+		for i, term := range s.terms {
+			if term.MatchString(text) {
+				flag(FlagProhibitedTerm + ":" + s.words[i])
+			}
+		}
+	}
+	return flags
+}
+
+// Hold reports whether a request with the given flags waits for review
+// before it is published.
+func (s *Screener) Hold(flags []string) bool {
+	switch s.mode {
+	case ModeAll:
+		return true
+	case ModeFlagged:
+		return len(flags) > 0
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package moderation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestContainsPhoneNumber(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"Call me on 555-123-4567", true},
+		{"+44 20 7946 0958", true},
+		{"(020) 7946 0958", true},
+		{"+1 (555) 123-4567", true},
+		{"text 07946095812 after 6pm", true},
+		{"+33612345678", true},
+		{"01 23 45 67 89", true},
+		{"555.123.4567", true},
+		{"on 2024-05-12 please", false},
+		{"12/05/2024", false},
+		{"12.05.2024", false},
+		{"2024-05-12 10:00", false},
+		{"from 10:00 - 12:00", false},
+		{"10.00-12.00", false},
+		{"the 2024-2025 season", false},
+		{"order 123456", false},
+		{"reference AB12345678", false},
+		{"between 9 and 17 on weekdays", false},
+	}
+	for _, tt := range tests {
+		if got := ContainsPhoneNumber(tt.text); got != tt.want {
+			t.Errorf("ContainsPhoneNumber(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestReplacePhoneNumbers(t *testing.T) {
+	got := ReplacePhoneNumbers("Ring 555-123-4567 on 2024-05-12 between 10:00 - 12:00", "[phone]")
+	want := "Ring [phone] on 2024-05-12 between 10:00 - 12:00"
+	if got != want {
+		t.Errorf("ReplacePhoneNumbers = %q, want %q", got, want)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	s, err := NewScreener(ModeFlagged, []string{" Cash ", "", "bank transfer"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name  string
+		texts []string
+		want  []string
+	}{
+		{"clean", []string{"Lift to the clinic", "Appointment on 2024-05-12 at 10:00"}, nil},
+		{"email", []string{"Mail me at jo@example.org"}, []string{FlagEmail}},
+		{"phone", []string{"Ring 07946 095812"}, []string{FlagPhone}},
+		{"terms match whole words regardless of case", []string{"CASH only", "cashier"}, []string{"prohibited_term:cash"}},
+		{"phrase", []string{"pay by bank transfer"}, []string{"prohibited_term:bank transfer"}},
+		{"duplicates are reported once", []string{"555-123-4567", "or 555-765-4321"}, []string{FlagPhone}},
+	}
+	for _, tt := range tests {
+		if got := s.Screen(tt.texts...); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Screen = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHold(t *testing.T) {
+	tests := []struct {
+		mode  string
+		flags []string
+		want  bool
+	}{
+		{ModeOff, []string{FlagPhone}, false},
+		{ModeFlagged, nil, false},
+		{ModeFlagged, []string{FlagPhone}, true},
+		{ModeAll, nil, true},
+	}
+	for _, tt := range tests {
+		s, err := NewScreener(tt.mode, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := s.Hold(tt.flags); got != tt.want {
+			t.Errorf("Hold(%s, %v) = %v, want %v", tt.mode, tt.flags, got, tt.want)
+		}
+	}
+	if _, err := NewScreener("sometimes", nil); err == nil {
+		t.Error("NewScreener accepted an unknown mode")
+	}
+}
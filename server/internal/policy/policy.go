@@ -0,0 +1,264 @@
+package policy
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Actions are written "resource:action". A grant may add a scope,
+// "resource:action:own|company|any", which limits it to resources the
+// user owns, resources of the user's company, or any resource. A grant
+// without a scope is unconditional.
+const (
+	PINProfileManage = "pin_profile:manage"
+	CSRProfileManage = "csr_profile:manage"
+	RequestCreate    = "request:create"
+	RequestRead      = "request:read"
+	RequestUpdate    = "request:update"
+	RequestSearch    = "request:search"
+	RequestModerate  = "request:moderate"
+	ShortlistManage  = "shortlist:manage"
+	ShortlistRead    = "shortlist:read"
+	MatchCreate      = "match:create"
+	MatchRead        = "match:read"
+	MatchUpdate      = "match:update"
+	MatchReassign    = "match:reassign"
+	MatchMessage     = "match:message"
+	MessageFlag      = "message:flag"
+	MessageModerate  = "message:moderate"
+	MessageUnmasked  = "message:read_unmasked"
+	TimesheetLog     = "timesheet:log"
+	TimesheetRead    = "timesheet:read"
+	TimesheetReview  = "timesheet:review"
+	HoursRead        = "hours:read"
+	RepManage        = "csr_rep:manage"
+	DashboardRead    = "dashboard:read"
+	CompanyRead      = "company:read"
+	CompanyManage    = "company:manage"
+	CategoryManage   = "category:manage"
+	ReportRead       = "report:read"
+	ReportGenerate   = "report:generate"
+	UserManage       = "user:manage"
+	UserImpersonate  = "user:impersonate"
+	AuditRead        = "audit:read"
+	APIKeyManage     = "api_key:manage"
+	TenantManage     = "tenant:manage"
+	AbuseReport      = "abuse_report:create"
+	AbuseTriage      = "abuse_report:triage"
+	UserBlock        = "user_block:manage"
+)
+
+const (
+	ScopeOwn     = "own"
+	ScopeCompany = "company"
+	ScopeAny     = "any"
+)
+
+// scopeRank orders scopes so a broader grant satisfies a narrower check.
+var scopeRank = map[string]int{ScopeOwn: 1, ScopeCompany: 2, ScopeAny: 3}
+
+func Own(action string) string     { return action + ":" + ScopeOwn }
+func Company(action string) string { return action + ":" + ScopeCompany }
+func Any(action string) string     { return action + ":" + ScopeAny }
+
+// Subject is the user a decision is made for. CompanyID is zero for users
+// not attached to a company.
+type Subject struct {
+	UserID    uint
+	Role      model.UserRole
+	CompanyID uint
+}
+
+// Owner is a user who owns a resource in the capacity of Role, such as the
+// PIN or the CSR rep of a match.
+type Owner struct {
+	UserID uint
+	Role   model.UserRole
+}
+
+// Resource describes the object being acted on: the users who own it and
+// the company it belongs to, if any.
+type Resource struct {
+	Owners    []Owner
+	CompanyID uint
+}
+
+// ownedBy reports whether subject owns the resource in the capacity of its
+// current role, so a grant to one role never reaches an owner of another.
+func (r Resource) ownedBy(subject Subject) bool {
+	for _, owner := range r.Owners {
+		if owner.UserID == subject.UserID && owner.Role == subject.Role {
+			return true
+		}
+	}
+	return false
+}
+
+// Policy maps roles to the permissions they are granted.
+type Policy struct {
+	roles map[model.UserRole][]string
+}
+
+// Default returns the built-in role mappings. A super-admin holds every
+// admin permission and also manages tenants.
+func Default() *Policy {
+	admin := []string{
+		Any(MatchMessage),
+		MessageFlag,
+		MessageModerate,
+		MessageUnmasked,
+		RequestModerate,
+		Any(TimesheetReview),
+		CompanyRead,
+		CompanyManage,
+		CategoryManage,
+		ReportRead,
+		ReportGenerate,
+		UserManage,
+		UserImpersonate,
+		AbuseTriage,
+		AuditRead,
+		APIKeyManage,
+	}
+	return &Policy{roles: map[model.UserRole][]string{
+		model.RolePIN: {
+			PINProfileManage,
+			RequestCreate,
+			Own(RequestRead),
+			Own(RequestUpdate),
+			Own(MatchRead),
+			Own(MatchMessage),
+			MessageFlag,
+			Own(TimesheetRead),
+			Own(TimesheetReview),
+			AbuseReport,
+			UserBlock,
+		},
+		model.RoleCSRRep: {
+			CSRProfileManage,
+			RequestSearch,
+			ShortlistManage,
+			MatchCreate,
+			Own(MatchRead),
+			Own(MatchUpdate),
+			Own(MatchMessage),
+			MessageFlag,
+			Own(TimesheetLog),
+			Own(TimesheetRead),
+			Own(HoursRead),
+			AbuseReport,
+			UserBlock,
+		},
+		model.RoleAdmin:      admin,
+		model.RoleSuperAdmin: append(admin[:len(admin):len(admin)], TenantManage),
+		model.RoleCompanyAdmin: {
+			Company(RepManage),
+			Company(ShortlistRead),
+			Company(MatchRead),
+			Company(MatchReassign),
+			Company(DashboardRead),
+			AbuseReport,
+		},
+		model.RolePlatform: {
+			CompanyRead,
+			CategoryManage,
+			RequestModerate,
+			ReportRead,
+			ReportGenerate,
+		},
+	}}
+}
+
+// LoadFile reads role mappings from a JSON file of the form
+// {"role": ["permission", ...]}. Roles in the file replace the defaults;
+// other roles keep their default permissions.
+func LoadFile(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var roles map[model.UserRole][]string
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	p := Default()
+	for role, grants := range roles {
+		for _, grant := range grants {
+			if err := validate(grant); err != nil {
+				return nil, fmt.Errorf("role %s: %w", role, err)
+			}
+		}
+		p.roles[role] = grants
+	}
+	return p, nil
+}
+
+func validate(grant string) error {
+	parts := strings.Split(grant, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("invalid permission %q", grant)
+	}
+	if len(parts) == 3 && scopeRank[parts[2]] == 0 {
+		return fmt.Errorf("invalid scope in permission %q", grant)
+	}
+	return nil
+}
+
+// split separates a permission into its action and scope.
+func split(permission string) (string, string) {
+	if i := strings.LastIndex(permission, ":"); i > 0 && scopeRank[permission[i+1:]] > 0 {
+		return permission[:i], permission[i+1:]
+	}
+	return permission, ""
+}
+
+// Permissions returns the grants of role.
+func (p *Policy) Permissions(role model.UserRole) []string {
+	return p.roles[role]
+}
+
+// Allows reports whether role holds permission without looking at a
+// resource. An unscoped permission is satisfied by any grant of the action;
+// a scoped one needs a grant of that scope or broader.
+func (p *Policy) Allows(role model.UserRole, permission string) bool {
+	action, scope := split(permission)
+	for _, grant := range p.roles[role] {
+		grantAction, grantScope := split(grant)
+		if grantAction != action {
+			continue
+		}
+		if scope == "" || grantScope == "" || scopeRank[grantScope] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// Can reports whether subject may perform action on resource.
+func (p *Policy) Can(subject Subject, action string, resource Resource) bool {
+	for _, grant := range p.roles[subject.Role] {
+		grantAction, scope := split(grant)
+		if grantAction != action {
+			continue
+		}
+		switch scope {
+		case "", ScopeAny:
+			return true
+		case ScopeCompany:
+			if subject.CompanyID != 0 && resource.CompanyID == subject.CompanyID {
+				return true
+			}
+			if resource.ownedBy(subject) {
+				return true
+			}
+		case ScopeOwn:
+			if resource.ownedBy(subject) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,163 @@
+package policy
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	pinUserID = 10
+	repUserID = 20
+	companyID = 5
+)
+
+// match is a match between PIN user 10 and CSR rep user 20 of company 5.
+var match = Resource{
+	Owners:    []Owner{{UserID: pinUserID, Role: model.RolePIN}, {UserID: repUserID, Role: model.RoleCSRRep}},
+	CompanyID: companyID,
+}
+
+func TestCan(t *testing.T) {
+	pin := Subject{UserID: pinUserID, Role: model.RolePIN}
+	otherPIN := Subject{UserID: 11, Role: model.RolePIN}
+	rep := Subject{UserID: repUserID, Role: model.RoleCSRRep, CompanyID: companyID}
+	colleague := Subject{UserID: 21, Role: model.RoleCSRRep, CompanyID: companyID}
+	companyAdmin := Subject{UserID: 30, Role: model.RoleCompanyAdmin, CompanyID: companyID}
+	otherCompanyAdmin := Subject{UserID: 31, Role: model.RoleCompanyAdmin, CompanyID: 6}
+	admin := Subject{UserID: 1, Role: model.RoleAdmin}
+	platform := Subject{UserID: 2, Role: model.RolePlatform}
+	// A user whose role changed keeps the ID recorded as the match's rep.
+	formerRep := Subject{UserID: repUserID, Role: model.RolePIN}
+
+	tests := []struct {
+		name    string
+		subject Subject
+		action  string
+		want    bool
+	}{
+		{"PIN reads own match", pin, MatchRead, true},
+		{"PIN reviews hours on own match", pin, TimesheetReview, true},
+		{"PIN cannot update a match", pin, MatchUpdate, false},
+		{"other PIN cannot read", otherPIN, MatchRead, false},
+		{"rep updates own match", rep, MatchUpdate, true},
+		{"rep logs time on own match", rep, TimesheetLog, true},
+		{"rep cannot review own hours", rep, TimesheetReview, false},
+		{"colleague cannot read", colleague, MatchRead, false},
+		{"company admin reads company match", companyAdmin, MatchRead, true},
+		{"company admin reassigns company match", companyAdmin, MatchReassign, true},
+		{"company admin cannot message", companyAdmin, MatchMessage, false},
+		{"other company admin cannot read", otherCompanyAdmin, MatchRead, false},
+		{"admin messages any match", admin, MatchMessage, true},
+		{"admin reviews any hours", admin, TimesheetReview, true},
+		{"admin cannot update a match", admin, MatchUpdate, false},
+		{"platform cannot read matches", platform, MatchRead, false},
+		{"owner in another role gets nothing", formerRep, MatchUpdate, false},
+		{"owner in another role cannot review", formerRep, TimesheetReview, false},
+	}
+	p := Default()
+	for _, tt := range tests {
+		if got := p.Can(tt.subject, tt.action, match); got != tt.want {
+			t.Errorf("%s: Can(%s) = %v, want %v", tt.name, tt.action, got, tt.want)
+		}
+	}
+}
+
+func TestCompanyScopeFallsBackToOwnership(t *testing.T) {
+	p := &Policy{roles: map[model.UserRole][]string{model.RoleCSRRep: {Company(MatchRead)}}}
+	companyless := match
+	companyless.CompanyID = 0
+	tests := []struct {
+		name     string
+		subject  Subject
+		resource Resource
+		want     bool
+	}{
+		{"same company", Subject{UserID: 21, Role: model.RoleCSRRep, CompanyID: companyID}, match, true},
+		{"owner without a company", Subject{UserID: repUserID, Role: model.RoleCSRRep}, match, true},
+		{"other company", Subject{UserID: 22, Role: model.RoleCSRRep, CompanyID: 6}, match, false},
+		{"no company on either side", Subject{UserID: 23, Role: model.RoleCSRRep}, companyless, false},
+	}
+	for _, tt := range tests {
+		if got := p.Can(tt.subject, MatchRead, tt.resource); got != tt.want {
+			t.Errorf("%s: Can = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAllows(t *testing.T) {
+	p := Default()
+	tests := []struct {
+		role       model.UserRole
+		permission string
+		want       bool
+	}{
+		{model.RoleAdmin, Any(TimesheetReview), true},
+		{model.RoleAdmin, Own(TimesheetReview), true},
+		{model.RoleAdmin, TimesheetReview, true},
+		{model.RolePIN, TimesheetReview, true},
+		{model.RolePIN, Own(TimesheetReview), true},
+		{model.RolePIN, Any(TimesheetReview), false},
+		{model.RoleCompanyAdmin, Own(MatchRead), true},
+		{model.RoleCompanyAdmin, Company(MatchRead), true},
+		{model.RoleCompanyAdmin, Any(MatchRead), false},
+		{model.RoleCSRRep, RequestSearch, true},
+		{model.RoleCSRRep, Any(RequestSearch), true},
+		{model.RolePlatform, UserManage, false},
+	}
+	for _, tt := range tests {
+		if got := p.Allows(tt.role, tt.permission); got != tt.want {
+			t.Errorf("Allows(%s, %s) = %v, want %v", tt.role, tt.permission, got, tt.want)
+		}
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	write := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "policy.json")
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("replaces listed roles only", func(t *testing.T) {
+		p, err := LoadFile(write(t, `{"company_admin": ["dashboard:read:company", "match:read:any"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !p.Allows(model.RoleCompanyAdmin, Any(MatchRead)) {
+			t.Error("file grant match:read:any was not applied")
+		}
+		if p.Allows(model.RoleCompanyAdmin, RepManage) {
+			t.Error("default company_admin grants survived the replacement")
+		}
+		if !p.Allows(model.RolePIN, RequestCreate) {
+			t.Error("roles missing from the file lost their defaults")
+		}
+	})
+
+	errorCases := []struct {
+		name, content, want string
+	}{
+		{"malformed JSON", `{"pin": [`, "parse"},
+		{"missing action", `{"pin": ["request"]}`, `invalid permission "request"`},
+		{"too many parts", `{"pin": ["request:read:own:extra"]}`, "invalid permission"},
+		{"unknown scope", `{"pin": ["request:read:team"]}`, "invalid scope"},
+	}
+	for _, tt := range errorCases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadFile(write(t, tt.content))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadFile error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadFile of a missing file succeeded")
+	}
+}
@@ -0,0 +1,137 @@
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at Rate
+// tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n events per minute with bursts of up to n.
+func PerMinute(n int) Limit { return Limit{Rate: float64(n) / 60, Burst: n} }
+
+// PerHour allows n events per hour with bursts of up to n.
+func PerHour(n int) Limit { return Limit{Rate: float64(n) / 3600, Burst: n} }
+
+// Store takes one token from the bucket identified by key. When the bucket
+// is empty it reports how long until a token is available.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+// StoreFunc adapts a function to the Store interface.
+type StoreFunc func(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error)
+
+func (f StoreFunc) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	return f(ctx, key, limit, now)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	limit   Limit
+}
+
+// MemoryStore keeps buckets in process memory. It is the default and is
+// sufficient for a single instance.
+type MemoryStore struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	takes   int
+}
+
+func NewMemoryStore() *MemoryStore { return &MemoryStore{buckets: make(map[string]*bucket)} }
+
+func (s *MemoryStore) Take(_ context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.takes++
+	if s.takes%1000 == 0 {
+		s.sweep(now)
+	}
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now, limit: limit}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	return false, RetryAfter(b.tokens, limit), nil
+}
+
+// sweep drops buckets that have refilled completely; they are equivalent to
+// a missing bucket.
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// RetryAfter returns how long a bucket holding tokens needs to refill one.
+func RetryAfter(tokens float64, limit Limit) time.Duration {
+	if limit.Rate <= 0 {
+		return time.Hour
+	}
+	return time.Duration((1 - tokens) / limit.Rate * float64(time.Second))
+}
+
+type Limiter struct {
+	store Store
+}
+
+func New(store Store) *Limiter { return &Limiter{store: store} }
+
+// Allow takes a token for key. A limit with no burst disables limiting.
+// Store failures are logged and the request is let through, so a database
+// hiccup cannot lock everybody out.
+func (l *Limiter) Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration) {
+	if limit.Burst <= 0 {
+		return true, 0
+	}
+	allowed, retryAfter, err := l.store.Take(ctx, key, limit, time.Now())
+	if err != nil {
+		slog.Error("rate limiter store failed", "key", key, "error", err)
+		return true, 0
+	}
+	return allowed, retryAfter
+}
+
+// Middleware limits requests per client IP under the given key prefix.
+func (l *Limiter) Middleware(prefix string, limit Limit) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allowed, retryAfter := l.Allow(c.Request.Context(), prefix+":ip:"+c.ClientIP(), limit); !allowed {
+			TooManyRequests(c, retryAfter)
+			return
+		}
+		c.Next()
+	}
+}
+
+// TooManyRequests aborts with 429 and a Retry-After header in whole seconds.
+func TooManyRequests(c *gin.Context, retryAfter time.Duration) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later", "retry_after": seconds})
+}
@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"csr-volunteer-matching/internal/logging"
+	"csr-volunteer-matching/internal/model"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchPINRequestsHidesBlockedPINs(t *testing.T) {
+	viewer, other := uint(7), uint(8)
+	searching := logging.WithIdentity(context.Background(), logging.Identity{UserID: viewer})
+	tests := []struct {
+		name        string
+		ctx         context.Context
+		filter      model.RequestFilter
+		wantBlocked bool
+	}{
+		{"no viewer", context.Background(), model.RequestFilter{}, false},
+		{"viewer in filter", context.Background(), model.RequestFilter{ViewerID: &viewer}, true},
+		{"searching user from the request", searching, model.RequestFilter{}, true},
+		{"filter viewer wins", logging.WithIdentity(context.Background(), logging.Identity{UserID: other}), model.RequestFilter{ViewerID: &viewer}, true},
+	}
+	for _, tt := range tests {
+		repo, recorder := dryRunRepository(t)
+		if _, _, err := repo.WithContext(tt.ctx).SearchPINRequests(tt.filter, 1, 20); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		sql := recorder.statements[0]
+		filtered := strings.Contains(sql, "user_blocks") && strings.Contains(sql, "ub.blocked_id = 7") && strings.Contains(sql, "ub.blocker_id = 7")
+		if filtered != tt.wantBlocked {
+			t.Errorf("%s: block filter applied = %v, want %v: %s", tt.name, filtered, tt.wantBlocked, sql)
+		}
+	}
+}
+
+func TestTriageAbuseReportComparesStatus(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	block := &model.UserBlock{BlockerID: 2, BlockedID: 3}
+	err := triageAbuseReport(repo.db, 9, model.AbuseReportOpen, model.AbuseReportResolved, "", 1, time.Now(), block)
+	// A dry run affects no rows, as when the report was triaged meanwhile.
+	if !errors.Is(err, ErrAbuseReportChanged) {
+		t.Fatalf("triageAbuseReport = %v, want %v", err, ErrAbuseReportChanged)
+	}
+	sql := strings.Join(recorder.statements, "\n")
+	if !strings.Contains(sql, "WHERE id = 9 AND status = 'open'") {
+		t.Errorf("update does not check the status it triages from:\n%s", sql)
+	}
+	if strings.Contains(sql, "user_blocks") {
+		t.Errorf("user blocked although the triage failed:\n%s", sql)
+	}
+}
@@ -0,0 +1,38 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func TestConsumeAuthTokenOnce(t *testing.T) {
+	now := time.Date(2024, 5, 12, 9, 0, 0, 0, time.UTC)
+	repo, recorder := scriptedRepository(t, scriptedResult{
+		match:   `UPDATE "auth_tokens"`,
+		columns: []string{"id", "user_id", "purpose", "token_hash"},
+		rows:    [][]driver.Value{{int64(3), int64(8), model.TokenPurposePasswordReset, "h"}},
+	})
+
+	token, err := repo.ConsumeAuthToken("h", model.TokenPurposePasswordReset, now)
+	if err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	if token.ID != 3 || token.UserID != 8 {
+		t.Errorf("first use returned token %d of user %d, want token 3 of user 8", token.ID, token.UserID)
+	}
+	sql := recorder.last()
+	for _, want := range []string{"used_at IS NULL", "expires_at > ", "purpose = 'password_reset'", "RETURNING"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("consume statement lacks %q: %s", want, sql)
+		}
+	}
+
+	if _, err := repo.ConsumeAuthToken("h", model.TokenPurposePasswordReset, now); err != gorm.ErrRecordNotFound {
+		t.Errorf("second use: err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
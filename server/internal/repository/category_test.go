@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"testing"
+	"time"
+)
+
+func TestCategoryOpen(t *testing.T) {
+	archived := time.Date(2024, 5, 12, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		category model.ServiceCategory
+		want     bool
+	}{
+		{"active", model.ServiceCategory{IsActive: true}, true},
+		{"inactive", model.ServiceCategory{IsActive: false}, false},
+		{"archived", model.ServiceCategory{IsActive: true, ArchivedAt: &archived}, false},
+		{"inactive and archived", model.ServiceCategory{ArchivedAt: &archived}, false},
+	}
+	for _, tt := range tests {
+		if got := categoryOpen(&tt.category); got != tt.want {
+			t.Errorf("%s: categoryOpen = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
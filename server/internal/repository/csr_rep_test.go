@@ -0,0 +1,28 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestUpdateCSRRepChecksNewCompany(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	// A dry run reads no rows, so the stored rep has no company and the
+	// update counts as a move.
+	err := updateCSRRep(repo.db, &model.CSRRep{ID: 4, UserID: 9, CompanyID: 2})
+	if !errors.Is(err, ErrCompanyNotVerified) {
+		t.Fatalf("move to an unverified company = %v, want %v", err, ErrCompanyNotVerified)
+	}
+	want := []string{"FROM \"csr_reps\"", "FOR UPDATE", "FROM \"companies\"", "FOR SHARE"}
+	sql := strings.Join(recorder.statements, "\n")
+	for _, fragment := range want {
+		if !strings.Contains(sql, fragment) {
+			t.Errorf("statements lack %q:\n%s", fragment, sql)
+		}
+	}
+	if strings.Contains(sql, "UPDATE \"csr_reps\"") {
+		t.Errorf("rep saved despite the company check failing:\n%s", sql)
+	}
+}
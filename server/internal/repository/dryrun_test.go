@@ -0,0 +1,118 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// sqlRecorder is a GORM logger that keeps the SQL of every statement.
+type sqlRecorder struct{ statements []string }
+
+func (r *sqlRecorder) LogMode(logger.LogLevel) logger.Interface      { return r }
+func (r *sqlRecorder) Info(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Warn(context.Context, string, ...interface{})  {}
+func (r *sqlRecorder) Error(context.Context, string, ...interface{}) {}
+func (r *sqlRecorder) Trace(_ context.Context, _ time.Time, fc func() (string, int64), _ error) {
+	sql, _ := fc()
+	r.statements = append(r.statements, sql)
+}
+
+// last returns the most recent statement.
+func (r *sqlRecorder) last() string {
+	if len(r.statements) == 0 {
+		return ""
+	}
+	return r.statements[len(r.statements)-1]
+}
+
+// dryRunRepository returns a repository whose statements are rendered, with
+// their arguments inlined, but never sent to a database.
+func dryRunRepository(t *testing.T) (*Repository, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=dry-run"}), &gorm.Config{
+		DryRun:                 true,
+		SkipDefaultTransaction: true,
+		DisableAutomaticPing:   true,
+		Logger:                 recorder,
+	})
+	if err != nil {
+		t.Fatalf("open dry-run database: %v", err)
+	}
+	return NewRepository(db), recorder
+}
+
+// scriptedResult answers queries whose SQL contains match. Its rows are
+// returned once; later matching queries get none, as a row a statement
+// claimed would no longer match.
+type scriptedResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// scriptedConn is a database/sql connection that answers queries from a
+// script and accepts every write, so whole repository methods, transactions
+// included, run without a database.
+type scriptedConn struct{ script []scriptedResult }
+
+func (c *scriptedConn) Connect(context.Context) (driver.Conn, error) { return c, nil }
+func (c *scriptedConn) Driver() driver.Driver                        { return nil }
+func (c *scriptedConn) Prepare(string) (driver.Stmt, error)          { return nil, driver.ErrSkip }
+func (c *scriptedConn) Close() error                                 { return nil }
+func (c *scriptedConn) Begin() (driver.Tx, error)                    { return c, nil }
+func (c *scriptedConn) Commit() error                                { return nil }
+func (c *scriptedConn) Rollback() error                              { return nil }
+func (c *scriptedConn) CheckNamedValue(*driver.NamedValue) error     { return nil }
+func (c *scriptedConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (c *scriptedConn) QueryContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Rows, error) {
+	for i, result := range c.script {
+		if strings.Contains(query, result.match) {
+			c.script[i].rows = nil
+			return &scriptedRows{columns: result.columns, rows: result.rows}, nil
+		}
+	}
+	return &scriptedRows{}, nil
+}
+
+type scriptedRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *scriptedRows) Columns() []string { return r.columns }
+func (r *scriptedRows) Close() error      { return nil }
+func (r *scriptedRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// scriptedRepository returns a repository backed by a scriptedConn. Queries
+// no result matches return no rows.
+func scriptedRepository(t *testing.T, script ...scriptedResult) (*Repository, *sqlRecorder) {
+	t.Helper()
+	recorder := &sqlRecorder{}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sql.OpenDB(&scriptedConn{script})}), &gorm.Config{
+		DisableAutomaticPing: true,
+		Logger:               recorder,
+	})
+	if err != nil {
+		t.Fatalf("open scripted database: %v", err)
+	}
+	return NewRepository(db), recorder
+}
@@ -0,0 +1,29 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"reflect"
+	"testing"
+)
+
+func TestMergeCategoryImpact(t *testing.T) {
+	matches := []model.CategoryImpact{
+		{CategoryID: 1, CategoryName: "Shopping", MatchesCompleted: 4},
+		{CategoryID: 2, CategoryName: "Transport", MatchesCompleted: 1},
+	}
+	hours := []model.CategoryImpact{
+		{CategoryID: 3, CategoryName: "Gardening", VolunteerHours: 12.5},
+		{CategoryID: 1, CategoryName: "Shopping", VolunteerHours: 6},
+	}
+	want := []model.CategoryImpact{
+		{CategoryID: 1, CategoryName: "Shopping", MatchesCompleted: 4, VolunteerHours: 6},
+		{CategoryID: 2, CategoryName: "Transport", MatchesCompleted: 1},
+		{CategoryID: 3, CategoryName: "Gardening", VolunteerHours: 12.5},
+	}
+	if got := mergeCategoryImpact(matches, hours); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeCategoryImpact = %+v, want %+v", got, want)
+	}
+	if got := mergeCategoryImpact(nil, hours[:1]); !reflect.DeepEqual(got, hours[:1]) {
+		t.Errorf("hours without completed matches = %+v, want %+v", got, hours[:1])
+	}
+}
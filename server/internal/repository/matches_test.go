@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gorm.io/gorm"
+)
+
+func TestCompletesMatch(t *testing.T) {
+	tests := []struct {
+		previous, next string
+		want           bool
+	}{
+		{"accepted", "completed", true},
+		{"in_progress", "completed", true},
+		{"completed", "completed", false},
+		{"accepted", "in_progress", false},
+		{"completed", "cancelled", false},
+	}
+	for _, tt := range tests {
+		if got := completesMatch(tt.previous, tt.next); got != tt.want {
+			t.Errorf("completesMatch(%q, %q) = %v, want %v", tt.previous, tt.next, got, tt.want)
+		}
+	}
+}
+
+// counterValue reads a counter from the default registry.
+func counterValue(t *testing.T, name string) float64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather metrics: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	t.Fatalf("metric %s is not registered", name)
+	return 0
+}
+
+func TestUpdateMatchCountsCompletion(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous string
+		next     string
+		want     float64
+	}{
+		{"completes", "in_progress", "completed", 1},
+		{"already completed", "completed", "completed", 0},
+		{"not completed", "accepted", "in_progress", 0},
+	}
+	for _, tt := range tests {
+		repo, _ := scriptedRepository(t, scriptedResult{
+			match:   `FROM "matches"`,
+			columns: []string{"id", "status"},
+			rows:    [][]driver.Value{{int64(4), tt.previous}},
+		})
+		before := counterValue(t, "csr_matches_completed_total")
+		if err := repo.UpdateMatch(&model.Match{ID: 4, Status: tt.next}); err != nil {
+			t.Fatalf("%s: UpdateMatch: %v", tt.name, err)
+		}
+		if got := counterValue(t, "csr_matches_completed_total") - before; got != tt.want {
+			t.Errorf("%s: matches completed grew by %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSaveMatchReturnsPreviousStatus(t *testing.T) {
+	repo, recorder := scriptedRepository(t, scriptedResult{
+		match:   `FROM "matches"`,
+		columns: []string{"id", "status"},
+		rows:    [][]driver.Value{{int64(4), "accepted"}},
+	})
+	previous, err := repo.SaveMatch(&model.Match{ID: 4, Status: "in_progress"})
+	if err != nil {
+		t.Fatalf("SaveMatch: %v", err)
+	}
+	if previous != "accepted" {
+		t.Errorf("previous status = %q, want %q", previous, "accepted")
+	}
+	if !strings.Contains(recorder.statements[0], "FOR UPDATE") {
+		t.Errorf("previous status read without a row lock: %s", recorder.statements[0])
+	}
+}
+
+func TestSaveMatchMissing(t *testing.T) {
+	repo, _ := scriptedRepository(t)
+	if _, err := repo.SaveMatch(&model.Match{ID: 4, Status: "completed"}); err != gorm.ErrRecordNotFound {
+		t.Errorf("err = %v, want %v", err, gorm.ErrRecordNotFound)
+	}
+}
+
+func TestCreatePINRequestCountsRequest(t *testing.T) {
+	repo, _ := scriptedRepository(t, scriptedResult{
+		match:   `FROM "service_categories"`,
+		columns: []string{"id", "is_active"},
+		rows:    [][]driver.Value{{int64(2), true}},
+	})
+	before := counterValue(t, "csr_pin_requests_created_total")
+	if err := repo.CreatePINRequest(&model.PINRequest{PINID: 1, CategoryID: 2, Title: "Groceries"}); err != nil {
+		t.Fatalf("CreatePINRequest: %v", err)
+	}
+	if got := counterValue(t, "csr_pin_requests_created_total") - before; got != 1 {
+		t.Errorf("requests created grew by %v, want 1", got)
+	}
+}
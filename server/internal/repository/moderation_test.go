@@ -0,0 +1,140 @@
+package repository
+
+import (
+	"context"
+	"csr-volunteer-matching/internal/model"
+	"csr-volunteer-matching/internal/moderation"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScreenRequest(t *testing.T) {
+	clean := model.PINRequest{Title: "Lift to the clinic", Description: "Tuesday morning"}
+	withPhone := model.PINRequest{Title: "Lift to the clinic", Description: "Ring 07946 095812"}
+	tests := []struct {
+		name       string
+		mode       string
+		request    model.PINRequest
+		status     string
+		wantStatus string
+		wantFlags  string
+	}{
+		{"off publishes as is", moderation.ModeOff, withPhone, model.ModerationApproved, model.ModerationApproved, ""},
+		{"flagged publishes clean requests", moderation.ModeFlagged, clean, model.ModerationApproved, model.ModerationApproved, ""},
+		{"flagged holds flagged requests", moderation.ModeFlagged, withPhone, model.ModerationApproved, model.ModerationPending, moderation.FlagPhone},
+		{"all holds clean requests", moderation.ModeAll, clean, model.ModerationApproved, model.ModerationPending, ""},
+		{"edited rejected request is reviewed again", moderation.ModeFlagged, clean, model.ModerationRejected, model.ModerationPending, ""},
+		{"edited request sent back is reviewed again", moderation.ModeFlagged, clean, model.ModerationChangesRequested, model.ModerationPending, ""},
+		{"edited pending request is published once clean", moderation.ModeFlagged, clean, model.ModerationPending, model.ModerationApproved, ""},
+	}
+	for _, tt := range tests {
+		screener, err := moderation.NewScreener(tt.mode, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := &Repository{screener: screener}
+		request := tt.request
+		request.ModerationStatus = tt.status
+		r.screenRequest(&request)
+		if request.ModerationStatus != tt.wantStatus || request.ModerationFlags != tt.wantFlags {
+			t.Errorf("%s: screened to %s %q, want %s %q", tt.name, request.ModerationStatus, request.ModerationFlags, tt.wantStatus, tt.wantFlags)
+		}
+	}
+}
+
+func TestScreenRequestWithoutScreener(t *testing.T) {
+	r := &Repository{}
+	request := model.PINRequest{Description: "Ring 07946 095812", ModerationStatus: model.ModerationApproved}
+	r.screenRequest(&request)
+	if request.ModerationStatus != model.ModerationApproved || request.ModerationFlags != "" {
+		t.Errorf("screened without a screener: %s %q", request.ModerationStatus, request.ModerationFlags)
+	}
+}
+
+// openCategory answers checkCategoryOpen for category 2.
+var openCategory = scriptedResult{
+	match:   `FROM "service_categories"`,
+	columns: []string{"id", "is_active"},
+	rows:    [][]driver.Value{{int64(2), true}},
+}
+
+func TestCreatePINRequestScreens(t *testing.T) {
+	screener, err := moderation.NewScreener(moderation.ModeFlagged, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name        string
+		description string
+		want        string
+	}{
+		{"flagged request held", "Ring 07946 095812", model.ModerationPending},
+		{"clean request published", "Tuesday morning", model.ModerationApproved},
+	}
+	for _, tt := range tests {
+		repo, recorder := scriptedRepository(t, openCategory)
+		// A status set by the caller is not trusted.
+		request := &model.PINRequest{PINID: 1, CategoryID: 2, Title: "Lift to the clinic", Description: tt.description, ModerationStatus: model.ModerationApproved}
+		if err := repo.WithScreener(screener).CreatePINRequest(request); err != nil {
+			t.Fatalf("%s: CreatePINRequest: %v", tt.name, err)
+		}
+		if request.ModerationStatus != tt.want {
+			t.Errorf("%s: moderation status = %s, want %s", tt.name, request.ModerationStatus, tt.want)
+		}
+		if sql := recorder.last(); !strings.HasPrefix(sql, `INSERT INTO "pin_requests"`) || !strings.Contains(sql, "'"+tt.want+"'") {
+			t.Errorf("%s: request stored as %s", tt.name, sql)
+		}
+	}
+}
+
+func TestScreenerSurvivesScoping(t *testing.T) {
+	screener, err := moderation.NewScreener(moderation.ModeAll, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, _ := dryRunRepository(t)
+	scoped := repo.WithScreener(screener).WithContext(context.Background())
+	request := &model.PINRequest{Title: "Lift to the clinic"}
+	scoped.screenRequest(request)
+	if request.ModerationStatus != model.ModerationPending {
+		t.Errorf("a context-scoped repository lost its screener: status %s", request.ModerationStatus)
+	}
+}
+
+func TestUpdatePINRequestScreensEditedText(t *testing.T) {
+	screener, err := moderation.NewScreener(moderation.ModeFlagged, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := scriptedResult{
+		match:   `FROM "pin_requests"`,
+		columns: []string{"id", "category_id", "title", "description", "moderation_status"},
+		rows:    [][]driver.Value{{int64(9), int64(2), "Lift to the clinic", "Tuesday morning", model.ModerationApproved}},
+	}
+	repo, recorder := scriptedRepository(t, stored)
+	request := &model.PINRequest{ID: 9, PINID: 1, CategoryID: 2, Title: "Lift to the clinic", Description: "Ring 07946 095812", ModerationStatus: model.ModerationApproved}
+	if err := repo.WithScreener(screener).UpdatePINRequest(request); err != nil {
+		t.Fatalf("UpdatePINRequest: %v", err)
+	}
+	if request.ModerationStatus != model.ModerationPending || request.ModerationFlags != moderation.FlagPhone {
+		t.Errorf("edited request screened to %s %q, want %s %q", request.ModerationStatus, request.ModerationFlags, model.ModerationPending, moderation.FlagPhone)
+	}
+	if sql := recorder.last(); !strings.Contains(sql, "'"+model.ModerationPending+"'") {
+		t.Errorf("edited request stored as %s", sql)
+	}
+}
+
+func TestSetRequestModerationComparesStatus(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	err := repo.SetRequestModeration(5, model.ModerationPending, model.ModerationApproved, "", 1, time.Now())
+	// A dry run affects no rows, as when the status moved on meanwhile.
+	if !errors.Is(err, ErrModerationChanged) {
+		t.Errorf("SetRequestModeration = %v, want %v", err, ErrModerationChanged)
+	}
+	if sql := recorder.last(); !strings.Contains(sql, "WHERE (id = 5 AND moderation_status = 'pending_review')") {
+		t.Errorf("update does not check the status it moderates from: %s", sql)
+	}
+}
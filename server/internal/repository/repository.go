@@ -1,7 +1,18 @@
 package repository
 
 import (
+	"context"
+	"csr-volunteer-matching/internal/logging"
+	"csr-volunteer-matching/internal/metrics"
 	"csr-volunteer-matching/internal/model"
+	"csr-volunteer-matching/internal/moderation"
+	"csr-volunteer-matching/internal/ratelimit"
+	"csr-volunteer-matching/internal/tenancy"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,34 +20,190 @@ import (
 )
 
 type Repository struct {
-	db *gorm.DB
+	db       *gorm.DB
+	screener *moderation.Screener
 }
 
 func NewRepository(db *gorm.DB) *Repository {
-	return &Repository{db}
+	return &Repository{db: db}
 }
 
+// WithScreener returns a repository that pre-screens requests with screener
+// as they are created or edited.
+func (r *Repository) WithScreener(screener *moderation.Screener) *Repository {
+	return &Repository{db: r.db, screener: screener}
+}
+
+// WithContext returns a repository whose statements run under ctx, so query
+// logs and traces carry the originating request's identifiers.
+func (r *Repository) WithContext(ctx context.Context) *Repository {
+	if r.db == nil {
+		return r
+	}
+	return &Repository{db: r.db.WithContext(ctx), screener: r.screener}
+}
+
+// SchemaVersion identifies the model set AutoMigrate creates. Bump it whenever
+// a model or index changes so readiness checks can tell which schema a
+// database carries.
+const SchemaVersion = 19
+
 // Auto-migrate all models
 func (r *Repository) AutoMigrate() error {
+	if err := r.ensureDefaultTenant(); err != nil {
+		return err
+	}
+	// Companies that predate verification were already trusted.
+	backfillVerified := r.db.Migrator().HasTable(&model.Company{}) && !r.db.Migrator().HasColumn(&model.Company{}, "VerificationStatus")
+	if err := r.dropGlobalAccountUniqueness(); err != nil {
+		return err
+	}
+	if err := r.autoMigrateModels(); err != nil {
+		return err
+	}
+	if backfillVerified {
+		if err := r.db.Model(&model.Company{}).Where("TRUE").Update("verification_status", model.CompanyVerified).Error; err != nil {
+			return err
+		}
+	}
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.SchemaMigration{Version: SchemaVersion, AppliedAt: time.Now()}).Error
+}
+
+// ensureDefaultTenant creates the tenants table and the default tenant that
+// rows from before multi-tenancy belong to, via their tenant_id default.
+func (r *Repository) ensureDefaultTenant() error {
+	if err := r.db.AutoMigrate(&model.Tenant{}); err != nil {
+		return err
+	}
+	tenant := &model.Tenant{ID: model.DefaultTenantID, Slug: "default", Name: "Default", IsActive: true}
+	if err := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(tenant).Error; err != nil {
+		return err
+	}
+	// The explicit ID does not advance the sequence.
+	return r.db.Exec("SELECT setval(pg_get_serial_sequence('tenants', 'id'), GREATEST((SELECT MAX(id) FROM tenants), 1))").Error
+}
+
+// dropGlobalAccountUniqueness removes the unique constraints that made
+// usernames, emails and SSO subjects unique across all tenants; the models
+// now declare them per tenant. Older databases name the constraints after
+// Postgres's convention rather than GORM's.
+func (r *Repository) dropGlobalAccountUniqueness() error {
+	if r.db.Migrator().HasTable(&model.User{}) {
+		for _, constraint := range []string{"uni_users_username", "users_username_key", "uni_users_email", "users_email_key"} {
+			if err := r.db.Exec("ALTER TABLE users DROP CONSTRAINT IF EXISTS " + constraint).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return r.db.Exec("DROP INDEX IF EXISTS idx_user_identity_subject").Error
+}
+
+// tenantFilter restricts a Table or Raw query to the tenant of the
+// repository's context. The tenancy plugin only scopes statements with a
+// model, so such queries add the filter to their driving table themselves.
+func (r *Repository) tenantFilter(alias string) (string, []interface{}) {
+	if tenantID, ok := tenancy.FromContext(r.db.Statement.Context); ok {
+		return fmt.Sprintf(" AND %s.tenant_id = ?", alias), []interface{}{tenantID}
+	}
+	return "", nil
+}
+
+func (r *Repository) autoMigrateModels() error {
 	return r.db.AutoMigrate(
+		&model.SchemaMigration{},
 		&model.User{},
 		&model.PIN{},
 		&model.CSRRep{},
+		&model.CompanyAdmin{},
 		&model.Company{},
 		&model.ServiceCategory{},
+		&model.ServiceCategoryTranslation{},
 		&model.PINRequest{},
 		&model.Shortlist{},
 		&model.Match{},
 		&model.ViewLog{},
 		&model.Report{},
+		&model.Conversation{},
+		&model.Message{},
+		&model.MessageAttachment{},
+		&model.MessageReceipt{},
+		&model.TimesheetEntry{},
+		&model.RateLimitBucket{},
+		&model.AuthToken{},
+		&model.RecoveryCode{},
+		&model.AuditLog{},
+		&model.CompanySSOConfig{},
+		&model.UserIdentity{},
+		&model.APIKey{},
+		&model.AbuseReport{},
+		&model.UserBlock{},
 	)
 }
 
+// Ping checks that the database is reachable.
+func (r *Repository) Ping(ctx context.Context) error {
+	if r.db == nil {
+		return fmt.Errorf("database not configured")
+	}
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// GetSchemaVersion returns the highest schema version applied to the database.
+func (r *Repository) GetSchemaVersion(ctx context.Context) (int, error) {
+	var version int
+	err := r.db.WithContext(ctx).Model(&model.SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// Tenant operations
+func (r *Repository) CreateTenant(tenant *model.Tenant) error { return r.db.Create(tenant).Error }
+func (r *Repository) UpdateTenant(tenant *model.Tenant) error { return r.db.Save(tenant).Error }
+func (r *Repository) GetTenantByID(id uint) (*model.Tenant, error) {
+	var tenant model.Tenant
+	err := r.db.First(&tenant, id).Error
+	return &tenant, err
+}
+func (r *Repository) GetTenantBySlug(slug string) (*model.Tenant, error) {
+	var tenant model.Tenant
+	err := r.db.Where("slug = ?", slug).First(&tenant).Error
+	return &tenant, err
+}
+func (r *Repository) ListTenants() ([]model.Tenant, error) {
+	var tenants []model.Tenant
+	err := r.db.Order("id").Find(&tenants).Error
+	return tenants, err
+}
+
+// GetTenantStats returns headline counts for every tenant. It reads across
+// tenants regardless of the context and is only exposed to super-admins.
+func (r *Repository) GetTenantStats(startDate, endDate time.Time) ([]model.TenantStats, error) {
+	var stats []model.TenantStats
+	err := r.db.Raw(`SELECT t.id AS tenant_id, t.slug, t.name,
+		(SELECT COUNT(*) FROM users u WHERE u.tenant_id = t.id AND u.deleted_at IS NULL) AS users,
+		(SELECT COUNT(*) FROM companies co WHERE co.tenant_id = t.id AND co.deleted_at IS NULL) AS companies,
+		(SELECT COUNT(*) FROM pin_requests pr WHERE pr.tenant_id = t.id AND pr.deleted_at IS NULL AND pr.created_at BETWEEN @start AND @end) AS requests,
+		(SELECT COUNT(*) FROM matches m WHERE m.tenant_id = t.id AND m.deleted_at IS NULL AND m.created_at BETWEEN @start AND @end) AS matches,
+		(SELECT COUNT(*) FROM matches m WHERE m.tenant_id = t.id AND m.deleted_at IS NULL AND m.status = 'completed' AND m.completed_at BETWEEN @start AND @end) AS completed_matches,
+		(SELECT COALESCE(SUM(te.hours), 0) FROM timesheet_entries te WHERE te.tenant_id = t.id AND te.deleted_at IS NULL AND te.status = 'confirmed' AND te.check_in_at BETWEEN @start AND @end) AS volunteer_hours
+		FROM tenants t ORDER BY t.id`, sql.Named("start", startDate), sql.Named("end", endDate)).Scan(&stats).Error
+	return stats, err
+}
+
 // User operations
 func (r *Repository) CreateUser(user *model.User) error { return r.db.Create(user).Error }
 func (r *Repository) GetUserByUsername(username string) (*model.User, error) {
 	var user model.User
-	err := r.db.Where("username = ?", username).First(&user).Error
+	err := r.db.Where("LOWER(username) = LOWER(?)", username).First(&user).Error
+	return &user, err
+}
+func (r *Repository) GetUserByEmail(email string) (*model.User, error) {
+	var user model.User
+	err := r.db.Where("LOWER(email) = LOWER(?)", email).First(&user).Error
 	return &user, err
 }
 func (r *Repository) GetUserByID(id uint) (*model.User, error) {
@@ -45,6 +212,98 @@ func (r *Repository) GetUserByID(id uint) (*model.User, error) {
 	return &user, err
 }
 func (r *Repository) UpdateUser(user *model.User) error { return r.db.Save(user).Error }
+func (r *Repository) SetUserActive(userID uint, active bool) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumn("is_active", active).Error
+}
+
+// ChangeUserRole sets a user's role and creates profile, the PIN, CSR rep
+// or company admin row the role needs, if given. The user's sessions and API
+// keys, granted under the old role, are revoked.
+func (r *Repository) ChangeUserRole(userID uint, role model.UserRole, profile interface{}, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error { return changeUserRole(tx, userID, role, profile, now) })
+}
+
+func changeUserRole(tx *gorm.DB, userID uint, role model.UserRole, profile interface{}, now time.Time) error {
+	if rep, ok := profile.(*model.CSRRep); ok {
+		if err := checkCompanyAcceptsReps(tx, rep.CompanyID); err != nil {
+			return err
+		}
+	}
+	if profile != nil {
+		if err := tx.Omit(clause.Associations).Create(profile).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Model(&model.User{}).Where("id = ?", userID).UpdateColumn("role", role).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&model.APIKey{}).Where("user_id = ? AND revoked_at IS NULL", userID).Update("revoked_at", now).Error; err != nil {
+		return err
+	}
+	return revokeSessions(tx, userID, now)
+}
+
+// RevokeSessions stamps the user's revocation time and invalidates their
+// outstanding SSO sessions, two-factor login challenges and impersonation
+// tokens.
+func (r *Repository) RevokeSessions(userID uint, now time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error { return revokeSessions(tx, userID, now) })
+}
+
+func revokeSessions(tx *gorm.DB, userID uint, now time.Time) error {
+	if err := tx.Model(&model.User{}).Where("id = ?", userID).UpdateColumn("sessions_revoked_at", now).Error; err != nil {
+		return err
+	}
+	return tx.Model(&model.AuthToken{}).
+		Where("user_id = ? AND purpose IN ? AND used_at IS NULL", userID, []string{model.TokenPurposeSession, model.TokenPurposeTwoFactorLogin, model.TokenPurposeImpersonation}).
+		Update("used_at", now).Error
+}
+
+func (r *Repository) SearchUsers(filter model.UserFilter, page, pageSize int) ([]model.User, int64, error) {
+	var users []model.User
+	var total int64
+	query := r.db.Model(&model.User{})
+	if filter.Role != nil {
+		query = query.Where("role = ?", *filter.Role)
+	}
+	if filter.Email != "" {
+		query = query.Where("LOWER(email) = LOWER(?)", filter.Email)
+	}
+	if filter.Query != "" {
+		query = query.Where("(username ILIKE ? OR email ILIKE ?)", "%"+filter.Query+"%", "%"+filter.Query+"%")
+	}
+	switch filter.Status {
+	case "active":
+		query = query.Where("is_active")
+	case "inactive":
+		query = query.Where("NOT is_active")
+	case "locked":
+		query = query.Where("locked_until > ?", time.Now())
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&users).Error
+	return users, total, err
+}
+
+// IncrementFailedLogins bumps the user's failed login counter and returns the
+// new value.
+func (r *Repository) IncrementFailedLogins(userID uint, at time.Time) (int, error) {
+	var attempts int
+	err := r.db.Raw("UPDATE users SET failed_login_attempts = failed_login_attempts + 1, last_failed_login_at = ? WHERE id = ? RETURNING failed_login_attempts", at, userID).Scan(&attempts).Error
+	return attempts, err
+}
+func (r *Repository) LockUser(userID uint, until time.Time) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumn("locked_until", until).Error
+}
+func (r *Repository) ResetFailedLogins(userID uint) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
 
 // PIN operations
 func (r *Repository) CreatePIN(pin *model.PIN) error { return r.db.Create(pin).Error }
@@ -55,17 +314,157 @@ func (r *Repository) GetPINByUserID(userID uint) (*model.PIN, error) {
 }
 func (r *Repository) UpdatePIN(pin *model.PIN) error { return r.db.Save(pin).Error }
 
+// ErrCompanyNotVerified is returned when a CSR rep would join a company that
+// is not verified, or is archived.
+var ErrCompanyNotVerified = errors.New("company is not verified or has been archived")
+
+// checkCompanyAcceptsReps locks the company row against a concurrent status
+// change and checks that it may take on new CSR reps.
+func checkCompanyAcceptsReps(tx *gorm.DB, companyID uint) error {
+	var company model.Company
+	if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&company, companyID).Error; err != nil {
+		return err
+	}
+	if company.VerificationStatus != model.CompanyVerified || company.ArchivedAt != nil {
+		return ErrCompanyNotVerified
+	}
+	return nil
+}
+
 // CSR Rep operations
-func (r *Repository) CreateCSRRep(csrRep *model.CSRRep) error { return r.db.Create(csrRep).Error }
+func (r *Repository) CreateCSRRep(csrRep *model.CSRRep) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCompanyAcceptsReps(tx, csrRep.CompanyID); err != nil {
+			return err
+		}
+		return tx.Create(csrRep).Error
+	})
+}
 func (r *Repository) GetCSRRepByUserID(userID uint) (*model.CSRRep, error) {
 	var csrRep model.CSRRep
 	err := r.db.Preload("User").Preload("Company").Where("user_id = ?", userID).First(&csrRep).Error
 	return &csrRep, err
 }
-func (r *Repository) UpdateCSRRep(csrRep *model.CSRRep) error { return r.db.Save(csrRep).Error }
+
+// UpdateCSRRep saves a CSR rep. Moving the rep to another company needs that
+// company to accept reps, as when the rep was created.
+func (r *Repository) UpdateCSRRep(csrRep *model.CSRRep) error {
+	return r.db.Transaction(func(tx *gorm.DB) error { return updateCSRRep(tx, csrRep) })
+}
+
+func updateCSRRep(tx *gorm.DB, csrRep *model.CSRRep) error {
+	var current model.CSRRep
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("company_id").First(&current, csrRep.ID).Error; err != nil {
+		return err
+	}
+	if current.CompanyID != csrRep.CompanyID {
+		if err := checkCompanyAcceptsReps(tx, csrRep.CompanyID); err != nil {
+			return err
+		}
+	}
+	return tx.Save(csrRep).Error
+}
+func (r *Repository) GetCSRRepByID(id uint) (*model.CSRRep, error) {
+	var csrRep model.CSRRep
+	err := r.db.Preload("User").Preload("Company").First(&csrRep, id).Error
+	return &csrRep, err
+}
+func (r *Repository) GetCSRRepsByCompanyID(companyID uint, page, pageSize int) ([]model.CSRRep, int64, error) {
+	var csrReps []model.CSRRep
+	var total int64
+	query := r.db.Model(&model.CSRRep{}).Where("company_id = ?", companyID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("User").Offset(offset).Limit(pageSize).Order("last_name, first_name").Find(&csrReps).Error
+	return csrReps, total, err
+}
+
+// CreateUserWithCSRRep creates a user and their CSR rep profile together.
+func (r *Repository) CreateUserWithCSRRep(user *model.User, csrRep *model.CSRRep) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCompanyAcceptsReps(tx, csrRep.CompanyID); err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		csrRep.UserID = user.ID
+		return tx.Omit(clause.Associations).Create(csrRep).Error
+	})
+}
+
+// Company admin operations
+func (r *Repository) GetCompanyAdminByUserID(userID uint) (*model.CompanyAdmin, error) {
+	var companyAdmin model.CompanyAdmin
+	err := r.db.Preload("User").Preload("Company").Where("user_id = ?", userID).First(&companyAdmin).Error
+	return &companyAdmin, err
+}
+
+// CreateUserWithCompanyAdmin creates a user and their company admin profile together.
+func (r *Repository) CreateUserWithCompanyAdmin(user *model.User, companyAdmin *model.CompanyAdmin) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		companyAdmin.UserID = user.ID
+		return tx.Omit(clause.Associations).Create(companyAdmin).Error
+	})
+}
+func (r *Repository) GetShortlistsByCompanyID(companyID uint, page, pageSize int) ([]model.Shortlist, int64, error) {
+	var shortlists []model.Shortlist
+	var total int64
+	query := r.db.Model(&model.Shortlist{}).Where("csr_rep_id IN (SELECT id FROM csr_reps WHERE company_id = ?)", companyID)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("CSRRep").Preload("Request").Preload("Request.Category").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&shortlists).Error
+	return shortlists, total, err
+}
+
+// GetCompanyActivityCounts returns rep, shortlist and per-status match counts
+// for a company's dashboard.
+func (r *Repository) GetCompanyActivityCounts(companyID uint) (*model.CompanyDashboard, error) {
+	dashboard := &model.CompanyDashboard{CompanyID: companyID, MatchesByStatus: map[string]int64{}}
+	reps := r.db.Model(&model.CSRRep{}).Joins("JOIN users ON users.id = csr_reps.user_id").Where("csr_reps.company_id = ?", companyID)
+	if err := reps.Session(&gorm.Session{}).Where("users.is_active").Count(&dashboard.ActiveReps).Error; err != nil {
+		return nil, err
+	}
+	if err := reps.Session(&gorm.Session{}).Where("NOT users.is_active").Count(&dashboard.InactiveReps).Error; err != nil {
+		return nil, err
+	}
+	companyReps := r.db.Model(&model.CSRRep{}).Select("id").Where("company_id = ?", companyID)
+	if err := r.db.Model(&model.Shortlist{}).Where("csr_rep_id IN (?)", companyReps).Count(&dashboard.Shortlists).Error; err != nil {
+		return nil, err
+	}
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := r.db.Model(&model.Match{}).Select("status, COUNT(*) AS count").Where("csr_rep_id IN (?)", companyReps).Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		dashboard.MatchesByStatus[row.Status] = row.Count
+	}
+	return dashboard, nil
+}
 
 // Company operations
-func (r *Repository) CreateCompany(company *model.Company) error { return r.db.Create(company).Error }
+
+// CreateCompany stores a new company. Review fields are reset so a company
+// always starts out pending verification.
+func (r *Repository) CreateCompany(company *model.Company) error {
+	company.VerificationStatus = model.CompanyPending
+	company.VerificationNotes = ""
+	company.ReviewedByID = nil
+	company.ReviewedAt = nil
+	company.ArchivedAt = nil
+	return r.db.Create(company).Error
+}
+func (r *Repository) UpdateCompany(company *model.Company) error { return r.db.Save(company).Error }
 func (r *Repository) GetCompanyByID(id uint) (*model.Company, error) {
 	var company model.Company
 	err := r.db.First(&company, id).Error
@@ -76,28 +475,238 @@ func (r *Repository) GetAllCompanies() ([]model.Company, error) {
 	err := r.db.Find(&companies).Error
 	return companies, err
 }
+func (r *Repository) SetCompanyVerification(id uint, status model.CompanyStatus, notes string, reviewerID uint, at time.Time) error {
+	return r.db.Model(&model.Company{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"verification_status": status,
+		"verification_notes":  notes,
+		"reviewed_by_id":      reviewerID,
+		"reviewed_at":         at,
+	}).Error
+}
+
+// SetCompanyArchived archives the company at the given time, or restores it
+// when at is nil.
+func (r *Repository) SetCompanyArchived(id uint, at *time.Time) error {
+	return r.db.Model(&model.Company{}).Where("id = ?", id).Update("archived_at", at).Error
+}
 
 // Service Category operations
+var (
+	ErrCategoryCycle        = errors.New("a category cannot be nested under itself or its subcategories")
+	ErrCategoryClosed       = errors.New("category is inactive or archived and takes no new requests")
+	ErrInvalidCategoryColor = errors.New("category color must be a hex color such as #1a7f5a")
+	categoryColorPattern    = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+)
+
+// categorySubtree matches rows whose column holds the given category or one
+// of its descendants; it takes the category ID as its only argument. UNION
+// rather than UNION ALL stops at a cycle should one ever be stored.
+func categorySubtree(column string) string {
+	return column + ` IN (WITH RECURSIVE subtree(id) AS (
+		SELECT id FROM service_categories WHERE id = ?
+		UNION SELECT sc.id FROM service_categories sc JOIN subtree ON sc.parent_id = subtree.id WHERE sc.deleted_at IS NULL
+	) SELECT id FROM subtree)`
+}
+
+// checkCategory validates a category's color and parent: the parent must
+// exist and must not be the category itself or one of its descendants.
+func checkCategory(tx *gorm.DB, category *model.ServiceCategory) error {
+	if category.Color != "" && !categoryColorPattern.MatchString(category.Color) {
+		return ErrInvalidCategoryColor
+	}
+	seen := map[uint]bool{}
+	for parentID := category.ParentID; parentID != nil; {
+		if (category.ID != 0 && *parentID == category.ID) || seen[*parentID] {
+			return ErrCategoryCycle
+		}
+		seen[*parentID] = true
+		var parent model.ServiceCategory
+		if err := tx.Select("id", "parent_id").First(&parent, *parentID).Error; err != nil {
+			return fmt.Errorf("parent category not found")
+		}
+		parentID = parent.ParentID
+	}
+	return nil
+}
+
+// categoryOpen reports whether a category takes new requests, and so may
+// receive those of a merged category.
+func categoryOpen(category *model.ServiceCategory) bool {
+	return category.IsActive && category.ArchivedAt == nil
+}
+
+// checkCategoryOpen fails unless the category takes new requests. The share
+// lock keeps it from being archived or merged until the caller commits.
+func checkCategoryOpen(tx *gorm.DB, categoryID uint) error {
+	var category model.ServiceCategory
+	if err := tx.Clauses(clause.Locking{Strength: "SHARE"}).First(&category, categoryID).Error; err != nil {
+		return fmt.Errorf("category not found")
+	}
+	if !categoryOpen(&category) {
+		return ErrCategoryClosed
+	}
+	return nil
+}
+
 func (r *Repository) CreateServiceCategory(category *model.ServiceCategory) error {
-	return r.db.Create(category).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCategory(tx, category); err != nil {
+			return err
+		}
+		return tx.Create(category).Error
+	})
 }
 func (r *Repository) GetServiceCategoryByID(id uint) (*model.ServiceCategory, error) {
 	var category model.ServiceCategory
-	err := r.db.First(&category, id).Error
+	err := r.db.Preload("Translations").First(&category, id).Error
 	return &category, err
 }
 func (r *Repository) GetAllServiceCategories() ([]model.ServiceCategory, error) {
 	var categories []model.ServiceCategory
-	err := r.db.Where("is_active = ?", true).Find(&categories).Error
+	err := r.db.Preload("Translations").Where("is_active = ? AND archived_at IS NULL", true).Order("sort_order, name").Find(&categories).Error
+	return categories, err
+}
+func (r *Repository) GetArchivedServiceCategories() ([]model.ServiceCategory, error) {
+	var categories []model.ServiceCategory
+	err := r.db.Preload("Translations").Where("archived_at IS NOT NULL").Order("archived_at DESC").Find(&categories).Error
 	return categories, err
 }
 func (r *Repository) UpdateServiceCategory(category *model.ServiceCategory) error {
-	return r.db.Save(category).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCategory(tx, category); err != nil {
+			return err
+		}
+		// Archival and merging have their own operations.
+		return tx.Omit("Translations", "ArchivedAt", "MergedIntoID").Save(category).Error
+	})
+}
+
+// SetCategoryArchived archives the category and its subcategories at the
+// given time, or restores them when at is nil. Categories merged away stay
+// archived.
+func (r *Repository) SetCategoryArchived(id uint, at *time.Time) (int64, error) {
+	result := r.db.Model(&model.ServiceCategory{}).
+		Where(categorySubtree("id"), id).Where("merged_into_id IS NULL").
+		Update("archived_at", at)
+	return result.RowsAffected, result.Error
+}
+
+// MergeServiceCategories moves every request (including deleted ones, so
+// history and analytics follow) and every subcategory of source to target,
+// then archives source as merged into target. Stored report snapshots keep
+// the old category; MergedIntoID resolves it.
+func (r *Repository) MergeServiceCategories(sourceID, targetID uint, at time.Time) (requests, subcategories int64, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var categories []model.ServiceCategory
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id IN ?", []uint{sourceID, targetID}).Order("id").Find(&categories).Error; err != nil {
+			return err
+		}
+		if len(categories) != 2 {
+			return fmt.Errorf("category not found")
+		}
+		for _, category := range categories {
+			if category.ID == sourceID && category.MergedIntoID != nil {
+				return fmt.Errorf("category has already been merged")
+			}
+			if category.ID == targetID && !categoryOpen(&category) {
+				return ErrCategoryClosed
+			}
+		}
+		var nested int64
+		if err := tx.Model(&model.ServiceCategory{}).Where(categorySubtree("id"), sourceID).Where("id = ?", targetID).Count(&nested).Error; err != nil {
+			return err
+		}
+		if nested > 0 {
+			return fmt.Errorf("cannot merge a category into one of its subcategories")
+		}
+		result := tx.Unscoped().Model(&model.PINRequest{}).Where("category_id = ?", sourceID).UpdateColumn("category_id", targetID)
+		if result.Error != nil {
+			return result.Error
+		}
+		requests = result.RowsAffected
+		result = tx.Model(&model.ServiceCategory{}).Where("parent_id = ?", sourceID).UpdateColumn("parent_id", targetID)
+		if result.Error != nil {
+			return result.Error
+		}
+		subcategories = result.RowsAffected
+		return tx.Model(&model.ServiceCategory{}).Where("id = ?", sourceID).Updates(map[string]interface{}{
+			"archived_at":    gorm.Expr("COALESCE(archived_at, ?)", at),
+			"merged_into_id": targetID,
+		}).Error
+	})
+	return requests, subcategories, err
+}
+
+// SaveCategoryTranslation creates or replaces the category's translation for
+// its locale.
+func (r *Repository) SaveCategoryTranslation(translation *model.ServiceCategoryTranslation) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "category_id"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "description", "updated_at"}),
+	}).Create(translation).Error
+}
+func (r *Repository) DeleteCategoryTranslation(categoryID uint, locale string) error {
+	result := r.db.Where("category_id = ? AND locale = ?", categoryID, locale).Delete(&model.ServiceCategoryTranslation{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
 }
 
 // PIN Request operations
+var (
+	ErrRequestNotPublished = errors.New("request is not published")
+	// ErrRequestNotFound hides requests a user has a block with; it reads
+	// like any other missing request.
+	ErrRequestNotFound = errors.New("request not found")
+)
+
+// screenRequest runs the pre-screen on a new or edited request and sets its
+// moderation status: held for review when the moderation mode says so, and
+// always after an edit to a request a moderator turned down.
+func (r *Repository) screenRequest(request *model.PINRequest) {
+	if !r.screener.Enabled() {
+		return
+	}
+	flags := r.screener.Screen(request.Title, request.Description, request.Location, request.SpecialNotes)
+	request.ModerationFlags = strings.Join(flags, ",")
+	switch {
+	case r.screener.Hold(flags), request.ModerationStatus == model.ModerationRejected, request.ModerationStatus == model.ModerationChangesRequested:
+		request.ModerationStatus = model.ModerationPending
+	default:
+		request.ModerationStatus = model.ModerationApproved
+	}
+}
+
+// checkRequestPublished fails unless the request has passed moderation.
+func checkRequestPublished(tx *gorm.DB, requestID uint) error {
+	var request model.PINRequest
+	if err := tx.Select("id", "moderation_status").First(&request, requestID).Error; err != nil {
+		return err
+	}
+	if request.ModerationStatus != model.ModerationApproved {
+		return ErrRequestNotPublished
+	}
+	return nil
+}
+
 func (r *Repository) CreatePINRequest(request *model.PINRequest) error {
-	return r.db.Create(request).Error
+	request.ModerationStatus = model.ModerationApproved
+	r.screenRequest(request)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCategoryOpen(tx, request.CategoryID); err != nil {
+			return err
+		}
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return err
+	}
+	metrics.RequestsCreated.Inc()
+	return nil
 }
 func (r *Repository) GetPINRequestByID(id uint) (*model.PINRequest, error) {
 	var request model.PINRequest
@@ -109,12 +718,27 @@ func (r *Repository) GetPINRequestsByPINID(pinID uint) ([]model.PINRequest, erro
 	err := r.db.Preload("Category").Where("pin_id = ?", pinID).Find(&requests).Error
 	return requests, err
 }
+
+// searchViewer returns the user a request search runs for: the filter's
+// viewer if set, otherwise the user the request context identifies.
+func (r *Repository) searchViewer(filter model.RequestFilter) (uint, bool) {
+	if filter.ViewerID != nil {
+		return *filter.ViewerID, true
+	}
+	identity, ok := logging.IdentityFromContext(r.db.Statement.Context)
+	return identity.UserID, ok && identity.UserID != 0
+}
+
 func (r *Repository) SearchPINRequests(filter model.RequestFilter, page, pageSize int) ([]model.PINRequest, int64, error) {
 	var requests []model.PINRequest
 	var total int64
-	query := r.db.Model(&model.PINRequest{}).Preload("PIN").Preload("PIN.User").Preload("Category")
+	query := r.db.Model(&model.PINRequest{}).Preload("PIN").Preload("PIN.User").Preload("Category").
+		Where("moderation_status = ?", model.ModerationApproved)
+	if viewer, ok := r.searchViewer(filter); ok {
+		query = query.Where(notBlocked("pin_requests.pin_id"), viewer, viewer)
+	}
 	if filter.CategoryID != nil {
-		query = query.Where("category_id = ?", *filter.CategoryID)
+		query = query.Where(categorySubtree("category_id"), *filter.CategoryID)
 	}
 	if filter.Status != nil {
 		query = query.Where("status = ?", *filter.Status)
@@ -141,8 +765,71 @@ func (r *Repository) SearchPINRequests(filter model.RequestFilter, page, pageSiz
 	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&requests).Error
 	return requests, total, err
 }
+
+// UpdatePINRequest saves the request. Moving it to another category requires
+// that category to take new requests; an archived current category is kept.
+// Edits to the text are screened again; other saves leave the moderation
+// state as the moderator set it.
 func (r *Repository) UpdatePINRequest(request *model.PINRequest) error {
-	return r.db.Save(request).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var current model.PINRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "category_id", "title", "description", "location", "special_notes", "moderation_status").
+			First(&current, request.ID).Error; err != nil {
+			return err
+		}
+		if current.CategoryID != request.CategoryID {
+			if err := checkCategoryOpen(tx, request.CategoryID); err != nil {
+				return err
+			}
+		}
+		if current.Title == request.Title && current.Description == request.Description &&
+			current.Location == request.Location && current.SpecialNotes == request.SpecialNotes {
+			return tx.Omit("ModerationStatus", "ModerationFlags", "ModerationNote", "ModeratedByID", "ModeratedAt").Save(request).Error
+		}
+		request.ModerationStatus = current.ModerationStatus
+		r.screenRequest(request)
+		return tx.Save(request).Error
+	})
+}
+
+// GetModerationQueue lists requests in the given moderation status, oldest
+// first, optionally only those the pre-screen flagged.
+func (r *Repository) GetModerationQueue(status string, flaggedOnly bool, page, pageSize int) ([]model.PINRequest, int64, error) {
+	var requests []model.PINRequest
+	var total int64
+	query := r.db.Model(&model.PINRequest{}).Where("moderation_status = ?", status)
+	if flaggedOnly {
+		query = query.Where("moderation_flags <> ''")
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("PIN").Preload("PIN.User").Preload("Category").Order("created_at ASC").Offset(offset).Limit(pageSize).Find(&requests).Error
+	return requests, total, err
+}
+
+// ErrModerationChanged is returned when a request's moderation status changed
+// since the moderator loaded it.
+var ErrModerationChanged = errors.New("the request has been moderated or edited meanwhile, reload it")
+
+// SetRequestModeration records a moderation decision, provided the request
+// is still in the from status.
+func (r *Repository) SetRequestModeration(id uint, from, status, note string, moderatorID uint, at time.Time) error {
+	result := r.db.Model(&model.PINRequest{}).Where("id = ? AND moderation_status = ?", id, from).Updates(map[string]interface{}{
+		"moderation_status": status,
+		"moderation_note":   note,
+		"moderated_by_id":   moderatorID,
+		"moderated_at":      at,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrModerationChanged
+	}
+	return nil
 }
 func (r *Repository) IncrementViewCount(requestID uint) error {
 	return r.db.Model(&model.PINRequest{}).Where("id = ?", requestID).UpdateColumn("view_count", gorm.Expr("view_count + 1")).Error
@@ -153,7 +840,15 @@ func (r *Repository) IncrementShortlistCount(requestID uint) error {
 
 // Shortlist operations
 func (r *Repository) CreateShortlist(shortlist *model.Shortlist) error {
-	return r.db.Create(shortlist).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkRequestPublished(tx, shortlist.RequestID); err != nil {
+			return err
+		}
+		if err := checkRepNotBlocked(tx, shortlist.RequestID, shortlist.CSRRepID); err != nil {
+			return err
+		}
+		return tx.Create(shortlist).Error
+	})
 }
 func (r *Repository) GetShortlistByCSRRepID(csrRepID uint) ([]model.Shortlist, error) {
 	var shortlists []model.Shortlist
@@ -174,7 +869,15 @@ func (r *Repository) CheckShortlistExists(csrRepID, requestID uint) (bool, error
 
 // Match operations
 func (r *Repository) CreateMatch(match *model.Match) error {
-	return r.db.Omit(clause.Associations).Create(match).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkRequestPublished(tx, match.RequestID); err != nil {
+			return err
+		}
+		if err := checkRepNotBlocked(tx, match.RequestID, match.CSRRepID); err != nil {
+			return err
+		}
+		return tx.Omit(clause.Associations).Create(match).Error
+	})
 }
 func (r *Repository) GetMatchByID(id uint) (*model.Match, error) {
 	var match model.Match
@@ -188,11 +891,14 @@ func (r *Repository) SearchMatches(filter model.MatchFilter, page, pageSize int)
 	if filter.CSRRepID != nil {
 		query = query.Where("csr_rep_id = ?", *filter.CSRRepID)
 	}
+	if filter.CompanyID != nil {
+		query = query.Where("csr_rep_id IN (SELECT id FROM csr_reps WHERE company_id = ?)", *filter.CompanyID)
+	}
 	if filter.PINID != nil {
 		query = query.Where("pin_id = ?", *filter.PINID)
 	}
 	if filter.CategoryID != nil {
-		query = query.Joins("JOIN pin_requests ON matches.request_id = pin_requests.id").Where("pin_requests.category_id = ?", *filter.CategoryID)
+		query = query.Joins("JOIN pin_requests ON matches.request_id = pin_requests.id").Where(categorySubtree("pin_requests.category_id"), *filter.CategoryID)
 	}
 	if filter.Status != nil {
 		query = query.Where("status = ?", *filter.Status)
@@ -210,13 +916,797 @@ func (r *Repository) SearchMatches(filter model.MatchFilter, page, pageSize int)
 	err := query.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&matches).Error
 	return matches, total, err
 }
-func (r *Repository) UpdateMatch(match *model.Match) error { return r.db.Save(match).Error }
+
+func (r *Repository) UpdateMatch(match *model.Match) error {
+	_, err := r.SaveMatch(match)
+	return err
+}
+
+// SaveMatch saves match and returns the status it had before, read under a
+// row lock so concurrent updates each see the status they replaced. The
+// update that completes a match is the one that counts it.
+func (r *Repository) SaveMatch(match *model.Match) (previousStatus string, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		var current model.Match
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "status").First(&current, match.ID).Error; err != nil {
+			return err
+		}
+		previousStatus = current.Status
+		return tx.Save(match).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	if completesMatch(previousStatus, match.Status) {
+		metrics.MatchesCompleted.Inc()
+	}
+	return previousStatus, nil
+}
+
+// completesMatch reports whether moving a match from previous to next
+// completes it.
+func completesMatch(previous, next string) bool {
+	return next == "completed" && previous != "completed"
+}
+
+func (r *Repository) ReassignMatch(matchID, csrRepID uint) error {
+	return r.db.Model(&model.Match{}).Where("id = ?", matchID).UpdateColumn("csr_rep_id", csrRepID).Error
+}
 
 // View Log operations
 func (r *Repository) CreateViewLog(viewLog *model.ViewLog) error { return r.db.Create(viewLog).Error }
 
+// RecordViews inserts a batch of view logs and brings the raw and distinct
+// viewer counters of every affected request up to date in one transaction.
+func (r *Repository) RecordViews(viewLogs []model.ViewLog) error {
+	if len(viewLogs) == 0 {
+		return nil
+	}
+	perRequest := make(map[uint]int)
+	for _, v := range viewLogs {
+		perRequest[v.RequestID]++
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).CreateInBatches(viewLogs, 100).Error; err != nil {
+			return err
+		}
+		for requestID, views := range perRequest {
+			if err := tx.Model(&model.PINRequest{}).Where("id = ?", requestID).UpdateColumns(map[string]interface{}{
+				"view_count":        gorm.Expr("view_count + ?", views),
+				"unique_view_count": gorm.Expr("(SELECT COUNT(DISTINCT csr_rep_id) FROM view_logs WHERE request_id = ?)", requestID),
+			}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Conversation operations
+func (r *Repository) GetOrCreateConversation(matchID uint) (*model.Conversation, error) {
+	conversation := model.Conversation{MatchID: matchID}
+	err := r.db.Where("match_id = ?", matchID).FirstOrCreate(&conversation).Error
+	return &conversation, err
+}
+func (r *Repository) GetConversationByMatchID(matchID uint) (*model.Conversation, error) {
+	var conversation model.Conversation
+	err := r.db.Where("match_id = ?", matchID).First(&conversation).Error
+	return &conversation, err
+}
+func (r *Repository) GetConversationByID(id uint) (*model.Conversation, error) {
+	var conversation model.Conversation
+	err := r.db.First(&conversation, id).Error
+	return &conversation, err
+}
+func (r *Repository) UpdateConversation(conversation *model.Conversation) error {
+	return r.db.Omit(clause.Associations).Save(conversation).Error
+}
+func (r *Repository) CreateMessage(message *model.Message) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Sender", "Receipts").Create(message).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.Conversation{}).Where("id = ?", message.ConversationID).UpdateColumn("last_message_at", message.CreatedAt).Error
+	})
+}
+func (r *Repository) GetMessageByID(id uint) (*model.Message, error) {
+	var message model.Message
+	err := r.db.Preload("Sender").Preload("Attachments").Preload("Receipts").First(&message, id).Error
+	return &message, err
+}
+func (r *Repository) GetMessagesByConversationID(conversationID uint, includeHidden bool, page, pageSize int) ([]model.Message, int64, error) {
+	var messages []model.Message
+	var total int64
+	query := r.db.Model(&model.Message{}).Where("conversation_id = ?", conversationID)
+	if !includeHidden {
+		query = query.Where("is_hidden = ?", false)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("Sender").Preload("Attachments").Preload("Receipts").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&messages).Error
+	return messages, total, err
+}
+func (r *Repository) UpdateMessage(message *model.Message) error {
+	return r.db.Omit(clause.Associations).Save(message).Error
+}
+func (r *Repository) GetFlaggedMessages(page, pageSize int) ([]model.Message, int64, error) {
+	var messages []model.Message
+	var total int64
+	query := r.db.Model(&model.Message{}).Where("is_flagged = ?", true)
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("Sender").Preload("Attachments").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&messages).Error
+	return messages, total, err
+}
+
+// MarkMessagesRead records a receipt for every message in the conversation
+// that was sent by someone other than userID and is not yet marked read.
+func (r *Repository) MarkMessagesRead(conversationID, userID uint, readAt time.Time) error {
+	return r.db.Exec(`INSERT INTO message_receipts (tenant_id, message_id, user_id, read_at)
+		SELECT tenant_id, id, ?, ? FROM messages
+		WHERE conversation_id = ? AND sender_id <> ? AND deleted_at IS NULL
+		ON CONFLICT (message_id, user_id) DO NOTHING`, userID, readAt, conversationID, userID).Error
+}
+func (r *Repository) CountUnreadMessages(conversationID, userID uint) (int64, error) {
+	var count int64
+	err := r.db.Model(&model.Message{}).
+		Where("conversation_id = ? AND sender_id <> ? AND is_hidden = ?", conversationID, userID, false).
+		Where("NOT EXISTS (SELECT 1 FROM message_receipts mr WHERE mr.message_id = messages.id AND mr.user_id = ?)", userID).
+		Count(&count).Error
+	return count, err
+}
+
+// Timesheet operations
+
+var ErrAlreadyCheckedIn = errors.New("already checked in to this match")
+
+// CreateTimesheetEntry opens a session. The partial unique index on open
+// sessions turns a concurrent second check-in into ErrAlreadyCheckedIn.
+func (r *Repository) CreateTimesheetEntry(entry *model.TimesheetEntry) error {
+	err := r.db.Create(entry).Error
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrAlreadyCheckedIn
+	}
+	return err
+}
+func (r *Repository) GetTimesheetEntryByID(id uint) (*model.TimesheetEntry, error) {
+	var entry model.TimesheetEntry
+	err := r.db.First(&entry, id).Error
+	return &entry, err
+}
+func (r *Repository) GetOpenTimesheetEntry(matchID, csrRepID uint) (*model.TimesheetEntry, error) {
+	var entry model.TimesheetEntry
+	err := r.db.Where("match_id = ? AND csr_rep_id = ? AND check_out_at IS NULL", matchID, csrRepID).First(&entry).Error
+	return &entry, err
+}
+func (r *Repository) GetTimesheetEntriesByMatchID(matchID uint) ([]model.TimesheetEntry, error) {
+	var entries []model.TimesheetEntry
+	err := r.db.Where("match_id = ?", matchID).Order("check_in_at DESC").Find(&entries).Error
+	return entries, err
+}
+func (r *Repository) UpdateTimesheetEntry(entry *model.TimesheetEntry) error {
+	return r.db.Save(entry).Error
+}
+
+// hoursQuery joins timesheet entries to their rep and company and applies the
+// filter; callers add the grouping they need.
+func (r *Repository) hoursQuery(filter model.HoursFilter) *gorm.DB {
+	tenantClause, tenantArgs := r.tenantFilter("te")
+	query := r.db.Table("timesheet_entries te").
+		Joins("JOIN csr_reps cr ON cr.id = te.csr_rep_id").
+		Joins("JOIN companies co ON co.id = cr.company_id").
+		Where("te.deleted_at IS NULL AND te.check_out_at IS NOT NULL"+tenantClause, tenantArgs...)
+	if filter.CSRRepID != nil {
+		query = query.Where("te.csr_rep_id = ?", *filter.CSRRepID)
+	}
+	if filter.CompanyID != nil {
+		query = query.Where("cr.company_id = ?", *filter.CompanyID)
+	}
+	if filter.StartDate != nil {
+		query = query.Where("te.check_in_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("te.check_in_at <= ?", *filter.EndDate)
+	}
+	return query
+}
+
+const hoursTotalsSelect = `COALESCE(SUM(CASE WHEN te.status = 'confirmed' THEN te.hours ELSE 0 END), 0) AS confirmed_hours,
+	COALESCE(SUM(CASE WHEN te.status = 'pending' THEN te.hours ELSE 0 END), 0) AS pending_hours,
+	COUNT(*) AS sessions`
+
+func (r *Repository) GetHoursByCSRRep(filter model.HoursFilter) ([]model.HoursTotal, error) {
+	var totals []model.HoursTotal
+	err := r.hoursQuery(filter).
+		Select("cr.id AS id, cr.first_name || ' ' || cr.last_name AS name, " + hoursTotalsSelect).
+		Group("cr.id, cr.first_name, cr.last_name").Order("confirmed_hours DESC").Scan(&totals).Error
+	return totals, err
+}
+func (r *Repository) GetHoursByCompany(filter model.HoursFilter) ([]model.HoursTotal, error) {
+	var totals []model.HoursTotal
+	err := r.hoursQuery(filter).
+		Select("co.id AS id, co.name AS name, " + hoursTotalsSelect).
+		Group("co.id, co.name").Order("confirmed_hours DESC").Scan(&totals).Error
+	return totals, err
+}
+
+// Impact operations
+func (r *Repository) GetCompanyImpactMetrics(companyID uint, startDate, endDate time.Time) (*model.ImpactMetrics, error) {
+	metrics := &model.ImpactMetrics{}
+	tenantClause, tenantArgs := r.tenantFilter("m")
+	completed := r.db.Table("matches m").
+		Joins("JOIN csr_reps cr ON cr.id = m.csr_rep_id").
+		Where("m.deleted_at IS NULL AND m.status = ? AND cr.company_id = ?"+tenantClause, append([]interface{}{"completed", companyID}, tenantArgs...)...).
+		Where("m.completed_at BETWEEN ? AND ?", startDate, endDate)
+
+	var totals struct {
+		MatchesCompleted    int64
+		BeneficiariesServed int64
+		ActiveVolunteers    int64
+		AverageRating       *float64
+	}
+	if err := completed.Session(&gorm.Session{}).
+		Select("COUNT(*) AS matches_completed, COUNT(DISTINCT m.pin_id) AS beneficiaries_served, COUNT(DISTINCT m.csr_rep_id) AS active_volunteers, AVG(m.rating) AS average_rating").
+		Scan(&totals).Error; err != nil {
+		return nil, err
+	}
+	metrics.MatchesCompleted = totals.MatchesCompleted
+	metrics.BeneficiariesServed = totals.BeneficiariesServed
+	metrics.ActiveVolunteers = totals.ActiveVolunteers
+	metrics.AverageRating = totals.AverageRating
+
+	hoursFilter := model.HoursFilter{CompanyID: &companyID, StartDate: &startDate, EndDate: &endDate}
+	if err := r.hoursQuery(hoursFilter).Where("te.status = ?", "confirmed").Select("COALESCE(SUM(te.hours), 0)").Scan(&metrics.VolunteerHours).Error; err != nil {
+		return nil, err
+	}
+
+	var byCategory []model.CategoryImpact
+	if err := completed.Session(&gorm.Session{}).
+		Joins("JOIN pin_requests pr ON pr.id = m.request_id").
+		Joins("JOIN service_categories sc ON sc.id = pr.category_id").
+		Select("sc.id AS category_id, sc.name AS category_name, COUNT(*) AS matches_completed").
+		Group("sc.id, sc.name").Order("matches_completed DESC").Scan(&byCategory).Error; err != nil {
+		return nil, err
+	}
+	var hoursByCategory []model.CategoryImpact
+	if err := r.hoursQuery(hoursFilter).Where("te.status = ?", "confirmed").
+		Joins("JOIN matches m ON m.id = te.match_id").
+		Joins("JOIN pin_requests pr ON pr.id = m.request_id").
+		Joins("JOIN service_categories sc ON sc.id = pr.category_id").
+		Select("sc.id AS category_id, sc.name AS category_name, SUM(te.hours) AS volunteer_hours").
+		Group("sc.id, sc.name").Order("volunteer_hours DESC").Scan(&hoursByCategory).Error; err != nil {
+		return nil, err
+	}
+	metrics.ByCategory = mergeCategoryImpact(byCategory, hoursByCategory)
+	return metrics, nil
+}
+
+// mergeCategoryImpact adds the confirmed hours per category to the completed
+// match counts. Hours are logged on matches that may still be in progress, so
+// categories with hours but no completed match are appended rather than lost.
+func mergeCategoryImpact(matches, hours []model.CategoryImpact) []model.CategoryImpact {
+	index := make(map[uint]int, len(matches))
+	for i, c := range matches {
+		index[c.CategoryID] = i
+	}
+	for _, h := range hours {
+		if i, ok := index[h.CategoryID]; ok {
+			matches[i].VolunteerHours = h.VolunteerHours
+			continue
+		}
+		matches = append(matches, model.CategoryImpact{CategoryID: h.CategoryID, CategoryName: h.CategoryName, VolunteerHours: h.VolunteerHours})
+	}
+	return matches
+}
+
+// Analytics operations
+
+// funnelStages selects the per-request funnel stage columns. The %s
+// placeholders receive an extra predicate restricting events to one company
+// (or nothing for the platform-wide funnel).
+const funnelStages = `
+	(SELECT MIN(vl.created_at) FROM view_logs vl JOIN csr_reps cr ON cr.id = vl.csr_rep_id WHERE vl.request_id = pr.id %[1]s) AS first_view_at,
+	EXISTS (SELECT 1 FROM shortlists s JOIN csr_reps cr ON cr.id = s.csr_rep_id WHERE s.request_id = pr.id %[1]s) AS shortlisted,
+	(SELECT MIN(m.created_at) FROM matches m JOIN csr_reps cr ON cr.id = m.csr_rep_id WHERE m.request_id = pr.id AND m.deleted_at IS NULL %[1]s) AS first_match_at,
+	EXISTS (SELECT 1 FROM matches m JOIN csr_reps cr ON cr.id = m.csr_rep_id WHERE m.request_id = pr.id AND m.deleted_at IS NULL AND m.status = 'completed' %[1]s) AS completed`
+
+const funnelAggregates = `COUNT(*) AS requests,
+	COUNT(first_view_at) AS viewed,
+	COUNT(*) FILTER (WHERE shortlisted) AS shortlisted,
+	COUNT(first_match_at) AS matched,
+	COUNT(*) FILTER (WHERE completed) AS completed,
+	percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_view_at - created_at)) / 3600 AS median_hours_to_first_view,
+	percentile_cont(0.5) WITHIN GROUP (ORDER BY EXTRACT(EPOCH FROM first_match_at - created_at)) / 3600 AS median_hours_to_match`
+
+// GetEngagementFunnel computes funnel rows for requests created in the filter
+// window, grouped by category, urgency, company or time bucket. For the
+// company grouping a request counts towards every company whose reps viewed,
+// shortlisted or matched it, and stages only consider that company's reps.
+// An empty GroupBy returns a single platform-wide row.
+func (r *Repository) GetEngagementFunnel(filter model.FunnelFilter) ([]model.FunnelRow, error) {
+	var key, label, from, companyClause string
+	switch filter.GroupBy {
+	case "":
+		key, label = "'all'", "'All requests'"
+	case "category":
+		key, label = "category_id::text", "category_name"
+	case "urgency":
+		key, label = "urgency", "urgency"
+	case "time":
+		switch filter.Bucket {
+		case "day", "week", "month":
+		default:
+			return nil, fmt.Errorf("invalid bucket %q", filter.Bucket)
+		}
+		key = fmt.Sprintf("to_char(date_trunc('%s', created_at), 'YYYY-MM-DD')", filter.Bucket)
+		label = key
+	case "company":
+		key, label = "company_id::text", "company_name"
+		from = `(SELECT cr.company_id, vl.request_id FROM view_logs vl JOIN csr_reps cr ON cr.id = vl.csr_rep_id
+			UNION SELECT cr.company_id, s.request_id FROM shortlists s JOIN csr_reps cr ON cr.id = s.csr_rep_id
+			UNION SELECT cr.company_id, m.request_id FROM matches m JOIN csr_reps cr ON cr.id = m.csr_rep_id WHERE m.deleted_at IS NULL
+		) p JOIN pin_requests pr ON pr.id = p.request_id JOIN companies co ON co.id = p.company_id`
+		companyClause = "AND cr.company_id = p.company_id"
+	default:
+		return nil, fmt.Errorf("invalid group_by %q", filter.GroupBy)
+	}
+	if from == "" {
+		from = "pin_requests pr"
+	}
+
+	columns := "pr.created_at, pr.urgency, pr.category_id, sc.name AS category_name"
+	if filter.GroupBy == "company" {
+		columns += ", p.company_id, co.name AS company_name"
+	}
+	args := []interface{}{filter.StartDate, filter.EndDate}
+	where := "pr.deleted_at IS NULL AND pr.created_at >= ? AND pr.created_at < ?"
+	if filter.CategoryID != nil {
+		where += " AND " + categorySubtree("pr.category_id")
+		args = append(args, *filter.CategoryID)
+	}
+	tenantClause, tenantArgs := r.tenantFilter("pr")
+	where += tenantClause
+	args = append(args, tenantArgs...)
+
+	sql := fmt.Sprintf(`WITH req AS (
+		SELECT %s, %s
+		FROM %s JOIN service_categories sc ON sc.id = pr.category_id
+		WHERE %s
+	)
+	SELECT %s AS key, %s AS label, %s
+	FROM req GROUP BY 1, 2 ORDER BY 1`,
+		columns, fmt.Sprintf(funnelStages, companyClause), from, where, key, label, funnelAggregates)
+
+	var rows []model.FunnelRow
+	err := r.db.Raw(sql, args...).Scan(&rows).Error
+	return rows, err
+}
+
+// Auth token operations
+func (r *Repository) CreateAuthToken(token *model.AuthToken) error { return r.db.Create(token).Error }
+
+// ConsumeAuthToken marks the unused, unexpired token with the given hash and
+// purpose as used and returns it. A token can only be consumed once, even
+// under concurrent requests.
+func (r *Repository) ConsumeAuthToken(tokenHash, purpose string, now time.Time) (*model.AuthToken, error) {
+	var token model.AuthToken
+	result := r.db.Model(&token).Clauses(clause.Returning{}).
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &token, nil
+}
+
+// GetActiveAuthToken returns an unused, unexpired token without consuming it.
+func (r *Repository) GetActiveAuthToken(tokenHash, purpose string, now time.Time) (*model.AuthToken, error) {
+	var token model.AuthToken
+	err := r.db.Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenHash, purpose, now).First(&token).Error
+	return &token, err
+}
+
+// InvalidateAuthTokens marks every outstanding token of a purpose as used.
+func (r *Repository) InvalidateAuthTokens(userID uint, purpose string, now time.Time) error {
+	return r.db.Model(&model.AuthToken{}).Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).Update("used_at", now).Error
+}
+func (r *Repository) SetUserPassword(userID uint, passwordHash string) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+		"password":              passwordHash,
+		"failed_login_attempts": 0,
+		"locked_until":          nil,
+	}).Error
+}
+func (r *Repository) MarkEmailVerified(userID uint, at time.Time) error {
+	return r.db.Model(&model.User{}).Where("id = ? AND email_verified_at IS NULL", userID).UpdateColumn("email_verified_at", at).Error
+}
+
+// Two-factor operations
+func (r *Repository) SetTOTPSecret(userID uint, encryptedSecret string) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+		"totp_secret":    encryptedSecret,
+		"totp_last_step": 0,
+	}).Error
+}
+func (r *Repository) EnableTwoFactor(userID uint, at time.Time) error {
+	return r.db.Model(&model.User{}).Where("id = ?", userID).UpdateColumn("two_factor_enabled_at", at).Error
+}
+
+// AdvanceTOTPStep records step as the last accepted TOTP step. It reports
+// false if an equal or later step was already used, which rejects replays.
+func (r *Repository) AdvanceTOTPStep(userID uint, step int64) (bool, error) {
+	result := r.db.Model(&model.User{}).Where("id = ? AND totp_last_step < ?", userID, step).UpdateColumn("totp_last_step", step)
+	return result.RowsAffected > 0, result.Error
+}
+
+// DisableTwoFactor clears the user's TOTP secret and recovery codes.
+func (r *Repository) DisableTwoFactor(userID uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+			"totp_secret":           "",
+			"totp_last_step":        0,
+			"two_factor_enabled_at": nil,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error
+	})
+}
+
+// ReplaceRecoveryCodes swaps the user's recovery codes for a new set.
+func (r *Repository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&model.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		codes := make([]model.RecoveryCode, len(codeHashes))
+		for i, h := range codeHashes {
+			codes[i] = model.RecoveryCode{UserID: userID, CodeHash: h}
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+// UseRecoveryCode marks a matching unused recovery code as used and reports
+// whether one was found.
+func (r *Repository) UseRecoveryCode(userID uint, codeHash string, now time.Time) (bool, error) {
+	result := r.db.Model(&model.RecoveryCode{}).Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).Update("used_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+// SSO operations
+func (r *Repository) GetCompanySSOConfig(companyID uint) (*model.CompanySSOConfig, error) {
+	var config model.CompanySSOConfig
+	err := r.db.Where("company_id = ?", companyID).First(&config).Error
+	return &config, err
+}
+
+// FindCompanySSOConfigByDomain returns the enabled SSO configuration that
+// lists domain among its allowed email domains.
+func (r *Repository) FindCompanySSOConfigByDomain(domain string) (*model.CompanySSOConfig, error) {
+	var config model.CompanySSOConfig
+	err := r.db.Where("enabled AND ? = ANY(string_to_array(allowed_email_domains, ','))", domain).First(&config).Error
+	return &config, err
+}
+
+// ErrSSODomainTaken is returned when another company's SSO configuration
+// already allows one of the email domains.
+var ErrSSODomainTaken = errors.New("email domain is already used by another company's single sign-on")
+
+const ssoDomainLockKey int64 = 0x4353525f53534f44 // "CSR_SSOD"
+
+// SaveCompanySSOConfig stores config unless another company of the tenant
+// already allows one of its email domains. The check and the write hold an
+// advisory lock so concurrent saves cannot both claim a domain.
+func (r *Repository) SaveCompanySSOConfig(config *model.CompanySSOConfig) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", ssoDomainLockKey).Error; err != nil {
+			return err
+		}
+		var taken []string
+		err := tx.Model(&model.CompanySSOConfig{}).
+			Joins("CROSS JOIN LATERAL unnest(string_to_array(company_sso_configs.allowed_email_domains, ',')) AS d(domain)").
+			Where("company_sso_configs.company_id <> ? AND d.domain IN ?", config.CompanyID, strings.Split(config.AllowedEmailDomains, ",")).
+			Distinct().Order("d.domain").Pluck("d.domain", &taken).Error
+		if err != nil {
+			return err
+		}
+		if len(taken) > 0 {
+			return fmt.Errorf("%w: %s", ErrSSODomainTaken, strings.Join(taken, ", "))
+		}
+		return tx.Omit(clause.Associations).Save(config).Error
+	})
+}
+func (r *Repository) DeleteCompanySSOConfig(companyID uint) error {
+	return r.db.Where("company_id = ?", companyID).Delete(&model.CompanySSOConfig{}).Error
+}
+func (r *Repository) GetUserIdentity(issuer, subject string) (*model.UserIdentity, error) {
+	var identity model.UserIdentity
+	err := r.db.Preload("User").Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	return &identity, err
+}
+func (r *Repository) CreateUserIdentity(identity *model.UserIdentity) error {
+	return r.db.Omit(clause.Associations).Create(identity).Error
+}
+
+// ProvisionSSOUser creates a user, their CSR rep profile and the identity
+// link in one transaction.
+func (r *Repository) ProvisionSSOUser(user *model.User, csrRep *model.CSRRep, identity *model.UserIdentity) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := checkCompanyAcceptsReps(tx, csrRep.CompanyID); err != nil {
+			return err
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		csrRep.UserID = user.ID
+		if err := tx.Omit(clause.Associations).Create(csrRep).Error; err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return tx.Omit(clause.Associations).Create(identity).Error
+	})
+}
+
+// API key operations
+func (r *Repository) CreateAPIKey(key *model.APIKey) error {
+	return r.db.Omit(clause.Associations).Create(key).Error
+}
+func (r *Repository) GetAPIKeyByID(id uint) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.Preload("User").First(&key, id).Error
+	return &key, err
+}
+func (r *Repository) GetAPIKeyByPrefix(prefix string) (*model.APIKey, error) {
+	var key model.APIKey
+	err := r.db.Preload("User").Where("prefix = ?", prefix).First(&key).Error
+	return &key, err
+}
+func (r *Repository) ListAPIKeys(userID *uint, page, pageSize int) ([]model.APIKey, int64, error) {
+	var keys []model.APIKey
+	var total int64
+	query := r.db.Model(&model.APIKey{})
+	if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("User").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&keys).Error
+	return keys, total, err
+}
+func (r *Repository) RevokeAPIKey(id uint, now time.Time) error {
+	return r.db.Model(&model.APIKey{}).Where("id = ? AND revoked_at IS NULL", id).Update("revoked_at", now).Error
+}
+
+// TouchAPIKey records when and from where a key was last used. Writes are
+// skipped while the stored timestamp is newer than interval ago, so busy
+// keys do not cost an UPDATE per request.
+func (r *Repository) TouchAPIKey(id uint, ip string, now time.Time, interval time.Duration) error {
+	return r.db.Model(&model.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", id, now.Add(-interval)).
+		UpdateColumns(map[string]interface{}{"last_used_at": now, "last_used_ip": ip}).Error
+}
+
+// Audit log operations
+func (r *Repository) CreateAuditLog(entry *model.AuditLog) error {
+	return r.db.Omit(clause.Associations).Create(entry).Error
+}
+func (r *Repository) SearchAuditLogs(filter model.AuditLogFilter, page, pageSize int) ([]model.AuditLog, int64, error) {
+	var logs []model.AuditLog
+	var total int64
+	query := r.db.Model(&model.AuditLog{})
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.Action != nil {
+		query = query.Where("action = ?", *filter.Action)
+	}
+	if filter.TargetType != nil {
+		query = query.Where("target_type = ?", *filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		query = query.Where("target_id = ?", *filter.TargetID)
+	}
+	if filter.StartDate != nil {
+		query = query.Where("created_at >= ?", *filter.StartDate)
+	}
+	if filter.EndDate != nil {
+		query = query.Where("created_at <= ?", *filter.EndDate)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("Actor").Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&logs).Error
+	return logs, total, err
+}
+
+// Abuse report operations
+
+// CreateAbuseReport stores a report. A reporter has at most one unhandled
+// report per target; another fails with gorm.ErrDuplicatedKey.
+func (r *Repository) CreateAbuseReport(report *model.AbuseReport) error {
+	return r.db.Create(report).Error
+}
+func (r *Repository) GetAbuseReportByID(id uint) (*model.AbuseReport, error) {
+	var report model.AbuseReport
+	err := r.db.Preload("Reporter").Preload("ReportedUser").First(&report, id).Error
+	return &report, err
+}
+
+func (r *Repository) SearchAbuseReports(filter model.AbuseReportFilter, page, pageSize int) ([]model.AbuseReport, int64, error) {
+	var reports []model.AbuseReport
+	var total int64
+	query := r.db.Model(&model.AbuseReport{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.TargetType != "" {
+		query = query.Where("target_type = ?", filter.TargetType)
+	}
+	if filter.ReportedUserID != nil {
+		query = query.Where("reported_user_id = ?", *filter.ReportedUserID)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	offset := (page - 1) * pageSize
+	err := query.Preload("Reporter").Preload("ReportedUser").Offset(offset).Limit(pageSize).Order("created_at ASC").Find(&reports).Error
+	return reports, total, err
+}
+
+// ErrAbuseReportChanged is returned when a report's status changed since the
+// caller loaded it.
+var ErrAbuseReportChanged = errors.New("the report has been triaged meanwhile, reload it")
+
+// TriageAbuseReport records a triage decision, provided the report is still
+// in the from status, and creates block, if given, in the same transaction.
+func (r *Repository) TriageAbuseReport(id uint, from, status, notes string, handlerID uint, at time.Time, block *model.UserBlock) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return triageAbuseReport(tx, id, from, status, notes, handlerID, at, block)
+	})
+}
+
+func triageAbuseReport(tx *gorm.DB, id uint, from, status, notes string, handlerID uint, at time.Time, block *model.UserBlock) error {
+	result := tx.Model(&model.AbuseReport{}).Where("id = ? AND status = ?", id, from).Updates(map[string]interface{}{
+		"status":           status,
+		"resolution_notes": notes,
+		"handled_by_id":    handlerID,
+		"handled_at":       at,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrAbuseReportChanged
+	}
+	if block == nil {
+		return nil
+	}
+	return createUserBlock(tx, block)
+}
+
+// User block operations
+
+// notBlocked matches rows whose PIN, given by a pins.id column, has no block
+// with a user in either direction; it takes that user's ID twice.
+func notBlocked(pinColumn string) string {
+	return `NOT EXISTS (SELECT 1 FROM user_blocks ub JOIN pins bp ON bp.id = ` + pinColumn + `
+		WHERE (ub.blocker_id = bp.user_id AND ub.blocked_id = ?) OR (ub.blocker_id = ? AND ub.blocked_id = bp.user_id))`
+}
+
+// checkRepNotBlocked fails if the request's PIN and the CSR rep have
+// blocked each other.
+func checkRepNotBlocked(tx *gorm.DB, requestID, csrRepID uint) error {
+	var blocked int64
+	err := tx.Raw(`SELECT COUNT(*) FROM pin_requests pr
+		JOIN pins p ON p.id = pr.pin_id
+		JOIN csr_reps cr ON cr.id = ?
+		JOIN user_blocks ub ON (ub.blocker_id = p.user_id AND ub.blocked_id = cr.user_id) OR (ub.blocker_id = cr.user_id AND ub.blocked_id = p.user_id)
+		WHERE pr.id = ?`, csrRepID, requestID).Scan(&blocked).Error
+	if err != nil {
+		return err
+	}
+	if blocked > 0 {
+		return ErrRequestNotFound
+	}
+	return nil
+}
+
+// CreateUserBlock records the block, if it does not exist yet, and removes
+// the shortlist entries either user's CSR rep profile holds on the other's
+// requests.
+func (r *Repository) CreateUserBlock(block *model.UserBlock) error {
+	return r.db.Transaction(func(tx *gorm.DB) error { return createUserBlock(tx, block) })
+}
+
+func createUserBlock(tx *gorm.DB, block *model.UserBlock) error {
+	if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(block).Error; err != nil {
+		return err
+	}
+	return tx.Where(`id IN (SELECT s.id FROM shortlists s
+		JOIN csr_reps cr ON cr.id = s.csr_rep_id
+		JOIN pin_requests pr ON pr.id = s.request_id
+		JOIN pins p ON p.id = pr.pin_id
+		WHERE (cr.user_id = @a AND p.user_id = @b) OR (cr.user_id = @b AND p.user_id = @a))`,
+		sql.Named("a", block.BlockerID), sql.Named("b", block.BlockedID)).
+		Delete(&model.Shortlist{}).Error
+}
+func (r *Repository) DeleteUserBlock(blockerID, blockedID uint) error {
+	result := r.db.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&model.UserBlock{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+func (r *Repository) GetUserBlocks(blockerID uint) ([]model.UserBlock, error) {
+	var blocks []model.UserBlock
+	err := r.db.Preload("Blocked").Where("blocker_id = ?", blockerID).Order("created_at DESC").Find(&blocks).Error
+	return blocks, err
+}
+
+// IsBlocked reports whether either user has blocked the other.
+func (r *Repository) IsBlocked(userID, otherID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&model.UserBlock{}).
+		Where("(blocker_id = ? AND blocked_id = ?) OR (blocker_id = ? AND blocked_id = ?)", userID, otherID, otherID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Rate limit operations
+
+// TakeRateLimitToken refills and takes a token from a shared token bucket in
+// a single upsert, so concurrent instances see a consistent count.
+func (r *Repository) TakeRateLimitToken(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (bool, time.Duration, error) {
+	var result struct {
+		Tokens  float64
+		Allowed bool
+	}
+	refill := "LEAST(@burst, b.tokens + EXTRACT(EPOCH FROM (@now - b.updated_at)) * @rate)"
+	err := r.db.WithContext(ctx).Raw(`INSERT INTO rate_limit_buckets AS b (key, tokens, allowed, updated_at)
+		VALUES (@key, @burst - 1, true, @now)
+		ON CONFLICT (key) DO UPDATE SET
+			tokens = CASE WHEN `+refill+` >= 1 THEN `+refill+` - 1 ELSE `+refill+` END,
+			allowed = `+refill+` >= 1,
+			updated_at = @now
+		RETURNING tokens, allowed`,
+		sql.Named("key", key), sql.Named("burst", float64(limit.Burst)), sql.Named("rate", limit.Rate), sql.Named("now", now)).
+		Scan(&result).Error
+	if err != nil || result.Allowed {
+		return result.Allowed, 0, err
+	}
+	return false, ratelimit.RetryAfter(result.Tokens, limit), nil
+}
+
+// PruneRateLimitBuckets removes buckets untouched since before cutoff.
+func (r *Repository) PruneRateLimitBuckets(cutoff time.Time) error {
+	return r.db.Where("updated_at < ?", cutoff).Delete(&model.RateLimitBucket{}).Error
+}
+
 // Report operations
+
 func (r *Repository) CreateReport(report *model.Report) error { return r.db.Create(report).Error }
+
+// CreateReportIfMissing stores a report unless one already exists for its
+// tenant, type and period, and reports whether it was created.
+func (r *Repository) CreateReportIfMissing(report *model.Report) (bool, error) {
+	result := r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+	return result.RowsAffected > 0, result.Error
+}
+
 func (r *Repository) GetReportsByType(reportType string, limit int) ([]model.Report, error) {
 	var reports []model.Report
 	query := r.db.Where("report_type = ?", reportType).Order("generated_at DESC")
@@ -227,6 +1717,32 @@ func (r *Repository) GetReportsByType(reportType string, limit int) ([]model.Rep
 	return reports, err
 }
 
+func (r *Repository) GetExistingReportPeriods(reportType string, periods []string) (map[string]bool, error) {
+	var found []string
+	err := r.db.Model(&model.Report{}).Where("report_type = ? AND period IN ?", reportType, periods).Pluck("period", &found).Error
+	existing := make(map[string]bool, len(found))
+	for _, p := range found {
+		existing[p] = true
+	}
+	return existing, err
+}
+
+// WithAdvisoryLock runs fn inside a transaction holding the Postgres advisory
+// lock identified by key. If another session holds the lock, fn is not run and
+// acquired is false. The lock is released when the transaction ends.
+func (r *Repository) WithAdvisoryLock(key int64, fn func(repo *Repository) error) (acquired bool, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", key).Scan(&acquired).Error; err != nil {
+			return err
+		}
+		if !acquired {
+			return nil
+		}
+		return fn(&Repository{db: tx, screener: r.screener})
+	})
+	return acquired, err
+}
+
 // Statistics operations
 func (r *Repository) GetRequestStats(startDate, endDate time.Time) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
@@ -265,5 +1781,18 @@ func (r *Repository) GetRequestStats(startDate, endDate time.Time) (map[string]i
 		return nil, err
 	}
 	stats["completed_matches"] = completedMatches
+
+	hoursFilter := model.HoursFilter{StartDate: &startDate, EndDate: &endDate}
+	var confirmedHours float64
+	if err := r.hoursQuery(hoursFilter).Where("te.status = ?", "confirmed").Select("COALESCE(SUM(te.hours), 0)").Scan(&confirmedHours).Error; err != nil {
+		return nil, err
+	}
+	stats["volunteer_hours"] = confirmedHours
+
+	hoursByCompany, err := r.GetHoursByCompany(hoursFilter)
+	if err != nil {
+		return nil, err
+	}
+	stats["volunteer_hours_by_company"] = hoursByCompany
 	return stats, nil
 }
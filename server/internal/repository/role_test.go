@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangeUserRole(t *testing.T) {
+	now := time.Date(2024, 5, 12, 15, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		role    model.UserRole
+		profile interface{}
+		want    []string
+	}{
+		{"no profile needed", model.RoleAdmin, nil, nil},
+		{"pin profile", model.RolePIN, &model.PIN{UserID: 3, FirstName: "Ada"}, []string{`INSERT INTO "pins"`}},
+		{"company admin profile", model.RoleCompanyAdmin, &model.CompanyAdmin{UserID: 3, CompanyID: 2, FirstName: "Ada"}, []string{`INSERT INTO "company_admins"`}},
+	}
+	for _, tt := range tests {
+		repo, recorder := dryRunRepository(t)
+		if err := changeUserRole(repo.db, 3, tt.role, tt.profile, now); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		sql := strings.Join(recorder.statements, "\n")
+		want := append(tt.want,
+			`UPDATE "users" SET "role"='`+string(tt.role)+`'`,
+			`UPDATE "api_keys" SET "revoked_at"=`,
+			`"sessions_revoked_at"=`,
+			`UPDATE "auth_tokens" SET "used_at"=`,
+		)
+		for _, fragment := range want {
+			if !strings.Contains(sql, fragment) {
+				t.Errorf("%s: statements lack %s:\n%s", tt.name, fragment, sql)
+			}
+		}
+	}
+}
+
+func TestChangeUserRoleChecksRepCompany(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	err := changeUserRole(repo.db, 3, model.RoleCSRRep, &model.CSRRep{UserID: 3, CompanyID: 2, FirstName: "Ada"}, time.Now())
+	if !errors.Is(err, ErrCompanyNotVerified) {
+		t.Fatalf("rep of an unverified company = %v, want %v", err, ErrCompanyNotVerified)
+	}
+	if sql := strings.Join(recorder.statements, "\n"); strings.Contains(sql, `UPDATE "users"`) {
+		t.Errorf("role changed despite the company check failing:\n%s", sql)
+	}
+}
@@ -0,0 +1,20 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindCompanySSOConfigByDomainMatchesExactly(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	for _, domain := range []string{"example.org", "%", "ex_mple.org", `ex\ample.org`} {
+		repo.FindCompanySSOConfigByDomain(domain)
+		sql := recorder.last()
+		if strings.Contains(sql, "LIKE") {
+			t.Errorf("%q: lookup uses a pattern match: %s", domain, sql)
+		}
+		if !strings.Contains(sql, "= ANY(string_to_array(allowed_email_domains, ','))") {
+			t.Errorf("%q: lookup is not an exact domain match: %s", domain, sql)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package repository
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAdvanceTOTPStepRejectsReplay(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	if _, err := repo.AdvanceTOTPStep(4, 1000); err != nil {
+		t.Fatalf("AdvanceTOTPStep: %v", err)
+	}
+	if sql := recorder.last(); !strings.Contains(sql, "totp_last_step < 1000") {
+		t.Errorf("a used step can be accepted again: %s", sql)
+	}
+}
+
+func TestUseRecoveryCodeOnce(t *testing.T) {
+	repo, recorder := dryRunRepository(t)
+	ok, err := repo.UseRecoveryCode(4, "hash", time.Now())
+	if err != nil {
+		t.Fatalf("UseRecoveryCode: %v", err)
+	}
+	if ok {
+		t.Error("a code was reported used although no row was updated")
+	}
+	sql := recorder.last()
+	for _, want := range []string{"user_id = 4", "code_hash = 'hash'", "used_at IS NULL"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("recovery code statement lacks %q: %s", want, sql)
+		}
+	}
+}
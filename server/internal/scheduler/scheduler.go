@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Job is a named unit of work run on a cron schedule. Schedules are evaluated
+// in UTC unless the spec sets CRON_TZ.
+type Job struct {
+	Name       string
+	Schedule   cron.Schedule
+	Run        func(ctx context.Context, now time.Time) error
+	RunOnStart bool
+}
+
+// Scheduler runs jobs in-process, one goroutine per job. A job never overlaps
+// with itself; a run that is still going when the next tick arrives delays it.
+type Scheduler struct {
+	jobs []Job
+	wg   sync.WaitGroup
+}
+
+func New() *Scheduler { return &Scheduler{} }
+
+// Add registers a job using a standard five-field cron expression
+// (minute hour day-of-month month day-of-week) or a descriptor such as
+// "@daily". An empty spec disables the job.
+func (s *Scheduler) Add(name, spec string, runOnStart bool, run func(ctx context.Context, now time.Time) error) error {
+	if spec == "" {
+		return nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return err
+	}
+	s.jobs = append(s.jobs, Job{Name: name, Schedule: schedule, Run: run, RunOnStart: runOnStart})
+	return nil
+}
+
+// Start launches every job and returns immediately. Jobs stop when ctx is
+// cancelled; use Wait to block until in-flight runs finish.
+func (s *Scheduler) Start(ctx context.Context) {
+	for _, job := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, job)
+	}
+}
+
+// Wait blocks until all job goroutines have returned.
+func (s *Scheduler) Wait() { s.wg.Wait() }
+
+func (s *Scheduler) loop(ctx context.Context, job Job) {
+	defer s.wg.Done()
+	if job.RunOnStart {
+		s.run(ctx, job, time.Now())
+	}
+	for {
+		next := job.next(time.Now())
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			s.run(ctx, job, now)
+		}
+	}
+}
+
+func (j Job) next(now time.Time) time.Time { return j.Schedule.Next(now.UTC()) }
+
+func (s *Scheduler) run(ctx context.Context, job Job, now time.Time) {
+	if err := job.Run(ctx, now); err != nil {
+		slog.Error("scheduled job failed", "job", job.Name, "error", err)
+	}
+}
@@ -0,0 +1,30 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+func TestJobNextRunsInUTC(t *testing.T) {
+	berlin := time.FixedZone("CEST", 2*60*60)
+	now := time.Date(2024, 5, 12, 1, 30, 0, 0, berlin) // 23:30 UTC on the 11th
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 2 * * *", time.Date(2024, 5, 12, 2, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Europe/London 0 2 * * *", time.Date(2024, 5, 12, 1, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		schedule, err := cron.ParseStandard(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseStandard(%q): %v", tt.spec, err)
+		}
+		if got := (Job{Schedule: schedule}).next(now); !got.Equal(tt.want) {
+			t.Errorf("%q: next = %v, want %v", tt.spec, got.UTC(), tt.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"testing"
+)
+
+func TestAbuseReportTransitionAllowed(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{model.AbuseReportOpen, model.AbuseReportInvestigating, true},
+		{model.AbuseReportOpen, model.AbuseReportResolved, true},
+		{model.AbuseReportOpen, model.AbuseReportDismissed, true},
+		{model.AbuseReportOpen, model.AbuseReportOpen, false},
+		{model.AbuseReportInvestigating, model.AbuseReportInvestigating, true},
+		{model.AbuseReportInvestigating, model.AbuseReportOpen, true},
+		{model.AbuseReportInvestigating, model.AbuseReportResolved, true},
+		{model.AbuseReportResolved, model.AbuseReportOpen, false},
+		{model.AbuseReportResolved, model.AbuseReportResolved, false},
+		{model.AbuseReportResolved, model.AbuseReportDismissed, false},
+		{model.AbuseReportDismissed, model.AbuseReportInvestigating, false},
+	}
+	for _, tt := range tests {
+		if got := abuseReportTransitionAllowed(tt.from, tt.to); got != tt.want {
+			t.Errorf("%s -> %s allowed = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
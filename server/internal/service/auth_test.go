@@ -0,0 +1,52 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNormalizeUsername(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"alice", "alice"},
+		{"Alice", "alice"},
+		{"  ALICE \t", "alice"},
+		{"no.such-user", "no.such-user"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeUsername(tt.in); got != tt.want {
+			t.Errorf("NormalizeUsername(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSessionRevoked(t *testing.T) {
+	revokedAt := time.Date(2024, 5, 12, 15, 0, 0, 500_000_000, time.UTC)
+	jwt := func(claims string) string {
+		return "eyJhbGciOiJIUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".sig"
+	}
+	tests := []struct {
+		name      string
+		revokedAt *time.Time
+		token     string
+		want      bool
+	}{
+		{"never revoked", nil, jwt(`{"iat":1}`), false},
+		{"issued before", &revokedAt, jwt(fmt.Sprintf(`{"iat":%d}`, revokedAt.Unix()-60)), true},
+		{"issued in the same second", &revokedAt, jwt(fmt.Sprintf(`{"iat":%d}`, revokedAt.Unix())), true},
+		{"issued after", &revokedAt, jwt(fmt.Sprintf(`{"iat":%d}`, revokedAt.Unix()+1)), false},
+		{"no iat", &revokedAt, jwt(`{"tenant_id":1}`), true},
+		{"no iat, never revoked", nil, jwt(`{"tenant_id":1}`), false},
+		{"api key", &revokedAt, APIKeyPrefix + "abc_secret", false},
+		{"sso session", &revokedAt, sessionTokenPrefix + "secret", false},
+		{"impersonation", &revokedAt, impersonationTokenPrefix + "secret", false},
+	}
+	for _, tt := range tests {
+		user := &model.User{SessionsRevokedAt: tt.revokedAt}
+		if got := SessionRevoked(user, tt.token); got != tt.want {
+			t.Errorf("%s: SessionRevoked = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
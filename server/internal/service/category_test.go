@@ -0,0 +1,43 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/config"
+	"csr-volunteer-matching/internal/model"
+	"testing"
+)
+
+func TestLocalizeCategory(t *testing.T) {
+	s := &Service{cfg: &config.Config{DefaultLocale: "en"}}
+	category := model.ServiceCategory{
+		Name: "Shopping",
+		Translations: []model.ServiceCategoryTranslation{
+			{Locale: "en-gb", Name: "Shopping (UK)"},
+			{Locale: "pt-br", Name: "Compras (BR)"},
+			{Locale: "pt", Name: "Compras"},
+			{Locale: "fr-ca", Name: "Magasinage"},
+		},
+	}
+	tests := []struct {
+		name       string
+		locales    []string
+		wantLocale string
+		wantName   string
+	}{
+		{"no preference", nil, "en", "Shopping"},
+		{"default", []string{"en"}, "en", "Shopping"},
+		{"regional translation of the default language", []string{"en-gb"}, "en-gb", "Shopping (UK)"},
+		{"other region of the default language", []string{"en-us"}, "en", "Shopping"},
+		{"exact regional translation", []string{"pt-br"}, "pt-br", "Compras (BR)"},
+		{"bare language preferred over another region", []string{"pt-pt"}, "pt", "Compras"},
+		{"only regional translation", []string{"fr"}, "fr-ca", "Magasinage"},
+		{"wildcard", []string{"*", "pt"}, "en", "Shopping"},
+		{"untranslated, then translated", []string{"de", "pt-br"}, "pt-br", "Compras (BR)"},
+		{"untranslated", []string{"de"}, "en", "Shopping"},
+	}
+	for _, tt := range tests {
+		got := s.localizeCategory(category, tt.locales)
+		if got.Locale != tt.wantLocale || got.Name != tt.wantName {
+			t.Errorf("%s: localized to %s %q, want %s %q", tt.name, got.Locale, got.Name, tt.wantLocale, tt.wantName)
+		}
+	}
+}
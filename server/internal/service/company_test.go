@@ -0,0 +1,51 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"testing"
+	"time"
+)
+
+func TestDashboardWindow(t *testing.T) {
+	now := time.Date(2024, 5, 12, 15, 0, 0, 0, time.UTC)
+	jan1 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		filter    model.HoursFilter
+		wantStart time.Time
+		wantEnd   time.Time
+	}{
+		{"year to date by default", model.HoursFilter{}, jan1, now},
+		{"start only", model.HoursFilter{StartDate: &march}, march, now},
+		{"end only", model.HoursFilter{EndDate: &april}, jan1, april},
+		{"both", model.HoursFilter{StartDate: &march, EndDate: &april}, march, april},
+	}
+	for _, tt := range tests {
+		start, end := dashboardWindow(tt.filter, now)
+		if !start.Equal(tt.wantStart) || !end.Equal(tt.wantEnd) {
+			t.Errorf("%s: window = %v..%v, want %v..%v", tt.name, start, end, tt.wantStart, tt.wantEnd)
+		}
+	}
+}
+
+func TestInvitationPending(t *testing.T) {
+	verified := time.Now()
+	tests := []struct {
+		name string
+		user model.User
+		role model.UserRole
+		want bool
+	}{
+		{"not yet accepted", model.User{Role: model.RoleCSRRep}, model.RoleCSRRep, true},
+		{"accepted", model.User{Role: model.RoleCSRRep, EmailVerifiedAt: &verified}, model.RoleCSRRep, false},
+		{"other role", model.User{Role: model.RolePIN}, model.RoleCSRRep, false},
+		{"company admin pending", model.User{Role: model.RoleCompanyAdmin}, model.RoleCompanyAdmin, true},
+	}
+	for _, tt := range tests {
+		if got := invitationPending(&tt.user, tt.role); got != tt.want {
+			t.Errorf("%s: invitationPending = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
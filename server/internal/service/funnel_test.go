@@ -0,0 +1,28 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/model"
+	"testing"
+)
+
+func TestFillFunnelRates(t *testing.T) {
+	tests := []struct {
+		name                               string
+		row                                model.FunnelRow
+		view, shortlist, match, completion float64
+	}{
+		{"no requests", model.FunnelRow{}, 0, 0, 0, 0},
+		{"every stage", model.FunnelRow{Requests: 8, Viewed: 6, Shortlisted: 4, Matched: 2, Completed: 1}, 0.75, 0.5, 0.25, 0.125},
+		{"nothing viewed", model.FunnelRow{Requests: 3}, 0, 0, 0, 0},
+		{"all completed", model.FunnelRow{Requests: 2, Viewed: 2, Shortlisted: 2, Matched: 2, Completed: 2}, 1, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		row := tt.row
+		fillFunnelRates(&row)
+		if row.ViewRate != tt.view || row.ShortlistRate != tt.shortlist || row.MatchRate != tt.match || row.CompletionRate != tt.completion {
+			t.Errorf("%s: rates = %v/%v/%v/%v, want %v/%v/%v/%v", tt.name,
+				row.ViewRate, row.ShortlistRate, row.MatchRate, row.CompletionRate,
+				tt.view, tt.shortlist, tt.match, tt.completion)
+		}
+	}
+}
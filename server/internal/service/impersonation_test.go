@@ -0,0 +1,48 @@
+package service
+
+import (
+	"context"
+	"csr-volunteer-matching/internal/logging"
+	"csr-volunteer-matching/internal/model"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestImpersonating(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want bool
+	}{
+		{"no identity", context.Background(), false},
+		{"user", logging.WithIdentity(context.Background(), logging.Identity{UserID: 3}), false},
+		{"admin acting as user", logging.WithIdentity(context.Background(), logging.Identity{UserID: 3, ImpersonatorID: 1}), true},
+	}
+	for _, tt := range tests {
+		s := &Service{ctx: tt.ctx}
+		if got := s.impersonating(); got != tt.want {
+			t.Errorf("%s: impersonating = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestImpersonationTargetGone(t *testing.T) {
+	tests := []struct {
+		name string
+		user *model.User
+		err  error
+		want bool
+	}{
+		{"active", &model.User{IsActive: true}, nil, false},
+		{"deactivated", &model.User{IsActive: false}, nil, true},
+		{"deleted", &model.User{}, gorm.ErrRecordNotFound, true},
+		{"lookup failed", &model.User{}, errors.New("connection reset"), false},
+	}
+	for _, tt := range tests {
+		if got := impersonationTargetGone(tt.user, tt.err); got != tt.want {
+			t.Errorf("%s: impersonationTargetGone = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
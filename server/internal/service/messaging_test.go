@@ -0,0 +1,21 @@
+package service
+
+import "testing"
+
+func TestMaskContactDetails(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Email me at jo.bloggs@example.org", "Email me at [email hidden]"},
+		{"Call 555-123-4567 or +44 20 7946 0958", "Call [phone hidden] or [phone hidden]"},
+		{"See you on 2024-05-12 from 10:00 - 12:00", "See you on 2024-05-12 from 10:00 - 12:00"},
+		{"Pick-up 12.05.2024, flat 3, 2nd floor", "Pick-up 12.05.2024, flat 3, 2nd floor"},
+		{"Booking ref AB12345678", "Booking ref AB12345678"},
+	}
+	for _, tt := range tests {
+		if got := MaskContactDetails(tt.text); got != tt.want {
+			t.Errorf("MaskContactDetails(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
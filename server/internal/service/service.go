@@ -1,14 +1,3124 @@
 package service
 
 import (
+    "context"
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "csr-volunteer-matching/internal/config"
+    "csr-volunteer-matching/internal/logging"
+    "csr-volunteer-matching/internal/mailer"
     "csr-volunteer-matching/internal/model"
+    "csr-volunteer-matching/internal/moderation"
+    "csr-volunteer-matching/internal/policy"
     "csr-volunteer-matching/internal/repository"
+    "csr-volunteer-matching/internal/tenancy"
+    "csr-volunteer-matching/internal/totp"
+    "csr-volunteer-matching/internal/tracing"
+    "encoding/base32"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
     "fmt"
+    "log/slog"
+    "math"
+    "regexp"
+    "strings"
+    "sync"
     "time"
+
+    "github.com/coreos/go-oidc/v3/oidc"
+    "go.opentelemetry.io/otel/trace"
+    "golang.org/x/crypto/bcrypt"
+    "golang.org/x/oauth2"
+    "gorm.io/gorm"
 )
 
-type Service struct { repo *repository.Repository }
-func NewService(repo *repository.Repository) *Service { return &Service{repo: repo} }
+type Service struct {
+    repo   *repository.Repository
+    cfg    *config.Config
+    mailer mailer.Mailer
+    views  *viewTracker
+    oidc   *oidcProviders
+    policy *policy.Policy
+    ctx    context.Context
+}
+func NewService(repo *repository.Repository, cfg *config.Config, mail mailer.Mailer, pol *policy.Policy) *Service {
+    return &Service{repo: repo, cfg: cfg, mailer: mail, views: newViewTracker(repo, cfg), oidc: &oidcProviders{byIssuer: map[string]*oidc.Provider{}}, policy: pol, ctx: context.Background()}
+}
+
+// WithContext returns a shallow copy of the service whose repository calls
+// run under ctx. Background workers such as the view tracker are shared.
+func (s *Service) WithContext(ctx context.Context) *Service {
+    clone := *s
+    clone.ctx = ctx
+    clone.repo = s.repo.WithContext(ctx)
+    return &clone
+}
+
+// forTenant returns a copy of the service scoped to tenantID, for flows that
+// only learn the tenant from a token or stored state.
+func (s *Service) forTenant(tenantID uint) *Service {
+    return s.WithContext(tenancy.WithTenant(s.ctx, tenantID))
+}
+
+// Unscoped returns a copy of the service that sees every tenant. It is used
+// to authenticate a request before its tenant is known.
+func (s *Service) Unscoped() *Service {
+    return s.WithContext(tenancy.WithoutTenant(s.ctx))
+}
+
+// startSpan opens a tracing span under the service's context and returns a
+// copy of the service bound to it, so repository statements nest inside.
+func (s *Service) startSpan(name string) (*Service, trace.Span) {
+    ctx, span := tracing.Start(s.ctx, name)
+    return s.WithContext(ctx), span
+}
 
 // ... existing code ...
 
+
+// Messaging
+
+var (
+    ErrNotMatchParticipant = errors.New("you are not a participant in this match")
+    ErrConversationLocked  = errors.New("this conversation is locked")
+)
+
+// MaskContactDetails replaces anything that looks like an email address or a
+// phone number so matched parties can coordinate without exchanging them.
+// Dates and times are left alone.
+func MaskContactDetails(text string) string {
+    text = moderation.EmailPattern.ReplaceAllString(text, "[email hidden]")
+    return moderation.ReplacePhoneNumbers(text, "[phone hidden]")
+}
+
+// loadMatchFor loads a match and checks that user may perform action on it.
+func (s *Service) loadMatchFor(user *model.User, action string, matchID uint) (*model.Match, error) {
+    match, err := s.repo.GetMatchByID(matchID)
+    if err != nil {
+        return nil, fmt.Errorf("match not found")
+    }
+    if err := s.authorizeMatch(user, action, match); err != nil {
+        return nil, err
+    }
+    return match, nil
+}
+
+// authorizeMatch checks that user may perform action on an already loaded
+// match.
+func (s *Service) authorizeMatch(user *model.User, action string, match *model.Match) error {
+    if !s.policy.Can(s.policySubject(user), action, MatchResource(match)) {
+        return ErrNotMatchParticipant
+    }
+    return nil
+}
+
+// maskMessages strips contact details from message bodies and sender accounts
+// unless the viewer may read messages unmasked.
+func (s *Service) maskMessages(viewer *model.User, messages []model.Message) {
+    if s.policy.Allows(viewer.Role, policy.MessageUnmasked) {
+        return
+    }
+    for i := range messages {
+        messages[i].Body = MaskContactDetails(messages[i].Body)
+        messages[i].Sender.Email = ""
+        messages[i].FlagReason = ""
+    }
+}
+
+func (s *Service) GetMatchConversation(user *model.User, match *model.Match, page, pageSize int) (response *model.PaginatedResponse, err error) {
+    s, span := s.startSpan("Service.GetMatchConversation")
+    defer func() { tracing.End(span, err) }()
+    if err := s.authorizeMatch(user, policy.MatchMessage, match); err != nil {
+        return nil, err
+    }
+    conversation, err := s.matchConversation(match.ID)
+    if err != nil {
+        return nil, err
+    }
+    moderator := s.policy.Allows(user.Role, policy.MessageModerate)
+    messages, total, err := s.repo.GetMessagesByConversationID(conversation.ID, moderator, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    unread, err := s.repo.CountUnreadMessages(conversation.ID, user.ID)
+    if err != nil {
+        return nil, err
+    }
+    s.maskMessages(user, messages)
+    return &model.PaginatedResponse{
+        Data: model.ConversationResponse{Conversation: *conversation, Messages: messages, UnreadCount: unread},
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// matchConversation returns the conversation of a match, creating it on
+// first read. An impersonating admin only sees an empty one, so reading
+// leaves no trace in the user's data.
+func (s *Service) matchConversation(matchID uint) (*model.Conversation, error) {
+    if !s.impersonating() {
+        return s.repo.GetOrCreateConversation(matchID)
+    }
+    conversation, err := s.repo.GetConversationByMatchID(matchID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return &model.Conversation{MatchID: matchID}, nil
+    }
+    return conversation, err
+}
+
+func (s *Service) SendMatchMessage(user *model.User, match *model.Match, req model.SendMessageRequest) (message *model.Message, err error) {
+    s, span := s.startSpan("Service.SendMatchMessage")
+    defer func() { tracing.End(span, err) }()
+    if err := s.authorizeMatch(user, policy.MatchMessage, match); err != nil {
+        return nil, err
+    }
+    moderator := s.policy.Allows(user.Role, policy.MessageModerate)
+    if match.Status == "cancelled" && !moderator {
+        return nil, fmt.Errorf("cannot send messages on a cancelled match")
+    }
+    conversation, err := s.repo.GetOrCreateConversation(match.ID)
+    if err != nil {
+        return nil, err
+    }
+    if conversation.IsLocked && !moderator {
+        return nil, ErrConversationLocked
+    }
+    body := strings.TrimSpace(req.Body)
+    if body == "" {
+        return nil, fmt.Errorf("message body cannot be empty")
+    }
+    message = &model.Message{ConversationID: conversation.ID, SenderID: user.ID, Body: body}
+    for _, a := range req.Attachments {
+        message.Attachments = append(message.Attachments, model.MessageAttachment{
+            FileName: a.FileName, URL: a.URL, ContentType: a.ContentType, SizeBytes: a.SizeBytes,
+        })
+    }
+    if err := s.repo.CreateMessage(message); err != nil {
+        return nil, err
+    }
+    message.Sender = *user
+    messages := []model.Message{*message}
+    s.maskMessages(user, messages)
+    return &messages[0], nil
+}
+
+func (s *Service) MarkMatchConversationRead(user *model.User, match *model.Match) error {
+    if err := s.authorizeMatch(user, policy.MatchMessage, match); err != nil {
+        return err
+    }
+    conversation, err := s.repo.GetOrCreateConversation(match.ID)
+    if err != nil {
+        return err
+    }
+    return s.repo.MarkMessagesRead(conversation.ID, user.ID, time.Now())
+}
+
+func (s *Service) FlagMessage(user *model.User, messageID uint, reason string) error {
+    message, err := s.repo.GetMessageByID(messageID)
+    if err != nil {
+        return fmt.Errorf("message not found")
+    }
+    conversation, err := s.repo.GetConversationByID(message.ConversationID)
+    if err != nil {
+        return err
+    }
+    if _, err := s.loadMatchFor(user, policy.MatchMessage, conversation.MatchID); err != nil {
+        return err
+    }
+    message.IsFlagged = true
+    message.FlagReason = reason
+    message.FlaggedByID = &user.ID
+    return s.repo.UpdateMessage(message)
+}
+
+func (s *Service) GetFlaggedMessages(page, pageSize int) (*model.PaginatedResponse, error) {
+    messages, total, err := s.repo.GetFlaggedMessages(page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: messages,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// ModerateMessage hides or restores a flagged message and clears the flag.
+func (s *Service) ModerateMessage(admin *model.User, messageID uint, hidden bool) (*model.Message, error) {
+    message, err := s.repo.GetMessageByID(messageID)
+    if err != nil {
+        return nil, fmt.Errorf("message not found")
+    }
+    now := time.Now()
+    message.IsHidden = hidden
+    message.IsFlagged = false
+    message.ModeratedByID = &admin.ID
+    message.ModeratedAt = &now
+    if err := s.repo.UpdateMessage(message); err != nil {
+        return nil, err
+    }
+    return message, nil
+}
+
+// Request moderation
+
+// ErrModerationChanged is returned when another moderator or an edit changed
+// the request's moderation status first.
+var ErrModerationChanged = repository.ErrModerationChanged
+
+var moderationDecisions = map[string]string{
+    "approve":         model.ModerationApproved,
+    "reject":          model.ModerationRejected,
+    "request_changes": model.ModerationChangesRequested,
+}
+
+// GetModerationQueue lists requests in a moderation status, pending review
+// by default.
+func (s *Service) GetModerationQueue(status string, flaggedOnly bool, page, pageSize int) (*model.PaginatedResponse, error) {
+    switch status {
+    case "":
+        status = model.ModerationPending
+    case model.ModerationPending, model.ModerationApproved, model.ModerationRejected, model.ModerationChangesRequested:
+    default:
+        return nil, fmt.Errorf("invalid moderation status %q", status)
+    }
+    requests, total, err := s.repo.GetModerationQueue(status, flaggedOnly, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: requests,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// ModerateRequest publishes a request, rejects it or sends it back to the
+// PIN for changes, and emails the PIN the outcome. Rejecting or asking for
+// changes needs a reason, which the PIN sees. Published requests can be
+// taken down the same way.
+func (s *Service) ModerateRequest(admin *model.User, requestID uint, req model.ModerateRequestRequest, ipAddress string) (*model.PINRequest, error) {
+    status := moderationDecisions[req.Decision]
+    req.Reason = strings.TrimSpace(req.Reason)
+    if status != model.ModerationApproved && req.Reason == "" {
+        return nil, fmt.Errorf("a reason is required to reject a request or ask for changes")
+    }
+    request, err := s.repo.GetPINRequestByID(requestID)
+    if err != nil {
+        return nil, fmt.Errorf("request not found")
+    }
+    if err := s.repo.SetRequestModeration(requestID, request.ModerationStatus, status, req.Reason, admin.ID, time.Now()); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "request.moderated", "pin_request", &requestID, map[string]interface{}{
+        "from": request.ModerationStatus, "to": status, "reason": req.Reason,
+    }, ipAddress)
+    if err := s.sendModerationOutcome(request, status, req.Reason); err != nil {
+        slog.Warn("failed to email moderation outcome", "request_id", requestID, "error", err)
+    }
+    return s.repo.GetPINRequestByID(requestID)
+}
+
+func (s *Service) sendModerationOutcome(request *model.PINRequest, status, reason string) error {
+    if request.PIN.User.Email == "" {
+        return nil
+    }
+    var outcome string
+    switch status {
+    case model.ModerationApproved:
+        outcome = "has been approved and is now visible to volunteers."
+    case model.ModerationRejected:
+        outcome = "was not approved.\n\nReason: " + reason
+    default:
+        outcome = "needs some changes before it can be published. Please edit it and it will be reviewed again.\n\nModerator's note: " + reason
+    }
+    return s.mailer.Send(s.ctx, mailer.Message{
+        To:      request.PIN.User.Email,
+        Subject: fmt.Sprintf("Your request %q", request.Title),
+        Body:    fmt.Sprintf("Hello,\n\nYour request %q %s\n\n%s/requests/%d\n", request.Title, outcome, s.cfg.AppBaseURL, request.ID),
+    })
+}
+
+// Requests
+
+// ErrRequestNotFound answers for requests a CSR rep may not see, whether
+// missing, unpublished or hidden by a block.
+var ErrRequestNotFound = repository.ErrRequestNotFound
+
+// Volunteer hours
+
+// matchRep returns the CSR rep profile of user, who must be the rep of match.
+func (s *Service) matchRep(user *model.User, match *model.Match) (*model.CSRRep, error) {
+    csrRep, err := s.repo.GetCSRRepByUserID(user.ID)
+    if err != nil {
+        return nil, fmt.Errorf("CSR profile not found")
+    }
+    if match.CSRRepID != csrRep.ID {
+        return nil, ErrNotMatchParticipant
+    }
+    return csrRep, nil
+}
+
+func (s *Service) CheckIn(user *model.User, match *model.Match, req model.CheckInRequest) (*model.TimesheetEntry, error) {
+    csrRep, err := s.matchRep(user, match)
+    if err != nil {
+        return nil, err
+    }
+    if match.Status == "cancelled" || match.Status == "completed" {
+        return nil, fmt.Errorf("cannot check in to a %s match", match.Status)
+    }
+    if _, err := s.repo.GetOpenTimesheetEntry(match.ID, csrRep.ID); err == nil {
+        return nil, repository.ErrAlreadyCheckedIn
+    } else if !errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, err
+    }
+    entry := &model.TimesheetEntry{
+        MatchID:    match.ID,
+        CSRRepID:   csrRep.ID,
+        CheckInAt:  time.Now(),
+        CheckInLat: req.Latitude,
+        CheckInLng: req.Longitude,
+        Status:     "open",
+        Notes:      req.Notes,
+    }
+    if err := s.repo.CreateTimesheetEntry(entry); err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+func (s *Service) CheckOut(user *model.User, match *model.Match, req model.CheckInRequest) (*model.TimesheetEntry, error) {
+    csrRep, err := s.matchRep(user, match)
+    if err != nil {
+        return nil, err
+    }
+    entry, err := s.repo.GetOpenTimesheetEntry(match.ID, csrRep.ID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, fmt.Errorf("not checked in to this match")
+    } else if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    entry.CheckOutAt = &now
+    entry.CheckOutLat = req.Latitude
+    entry.CheckOutLng = req.Longitude
+    entry.Hours = sessionHours(entry.CheckInAt, now)
+    entry.Status = "pending"
+    if req.Notes != "" {
+        entry.Notes = strings.TrimSpace(entry.Notes + "\n" + req.Notes)
+    }
+    if err := s.repo.UpdateTimesheetEntry(entry); err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+// maxSessionHours caps the hours a single session records, so a forgotten
+// check-out does not credit days of volunteering.
+const maxSessionHours = 24
+
+func sessionHours(checkIn, checkOut time.Time) float64 {
+    hours := math.Round(checkOut.Sub(checkIn).Hours()*100) / 100
+    return math.Max(0, math.Min(hours, maxSessionHours))
+}
+
+func (s *Service) GetMatchTimesheet(user *model.User, match *model.Match) ([]model.TimesheetEntry, error) {
+    if err := s.authorizeMatch(user, policy.TimesheetRead, match); err != nil {
+        return nil, err
+    }
+    return s.repo.GetTimesheetEntriesByMatchID(match.ID)
+}
+
+// ReviewHours lets a user holding timesheet:review for the match (by default
+// its PIN, or an admin coordinator) confirm or dispute a completed entry.
+// Without a grant for any match, only the match's PIN may review, so a rep
+// never confirms their own hours whatever the policy file says.
+func (s *Service) ReviewHours(user *model.User, entryID uint, req model.ConfirmHoursRequest) (*model.TimesheetEntry, error) {
+    entry, err := s.repo.GetTimesheetEntryByID(entryID)
+    if err != nil {
+        return nil, fmt.Errorf("timesheet entry not found")
+    }
+    match, err := s.loadMatchFor(user, policy.TimesheetReview, entry.MatchID)
+    if err != nil {
+        return nil, err
+    }
+    if !s.policy.Allows(user.Role, policy.Any(policy.TimesheetReview)) && match.PIN.UserID != user.ID {
+        return nil, ErrNotMatchParticipant
+    }
+    if entry.Status != "pending" && entry.Status != "disputed" {
+        return nil, fmt.Errorf("timesheet entry is %s and cannot be reviewed", entry.Status)
+    }
+    now := time.Now()
+    entry.Status = "disputed"
+    if req.Confirmed {
+        entry.Status = "confirmed"
+    }
+    entry.ConfirmedByID = &user.ID
+    entry.ConfirmedAt = &now
+    entry.ReviewNote = req.Note
+    if err := s.repo.UpdateTimesheetEntry(entry); err != nil {
+        return nil, err
+    }
+    return entry, nil
+}
+
+func (s *Service) GetCSRVolunteerHours(user *model.User, filter model.HoursFilter) (*model.HoursTotal, error) {
+    csrRep, err := s.repo.GetCSRRepByUserID(user.ID)
+    if err != nil {
+        return nil, fmt.Errorf("CSR profile not found")
+    }
+    filter.CSRRepID = &csrRep.ID
+    filter.CompanyID = nil
+    totals, err := s.repo.GetHoursByCSRRep(filter)
+    if err != nil {
+        return nil, err
+    }
+    if len(totals) == 0 {
+        return &model.HoursTotal{ID: csrRep.ID, Name: csrRep.FirstName + " " + csrRep.LastName}, nil
+    }
+    return &totals[0], nil
+}
+
+func (s *Service) GetVolunteerHoursReport(filter model.HoursFilter) (report map[string]interface{}, err error) {
+    s, span := s.startSpan("Service.GetVolunteerHoursReport")
+    defer func() { tracing.End(span, err) }()
+    byRep, err := s.repo.GetHoursByCSRRep(filter)
+    if err != nil {
+        return nil, err
+    }
+    byCompany, err := s.repo.GetHoursByCompany(filter)
+    if err != nil {
+        return nil, err
+    }
+    return map[string]interface{}{"by_csr_rep": byRep, "by_company": byCompany}, nil
+}
+
+// Company impact reports
+
+func percentChange(current, previous float64) *float64 {
+    if previous == 0 {
+        return nil
+    }
+    change := math.Round((current-previous)/previous*10000) / 100
+    return &change
+}
+
+func (s *Service) GenerateCompanyImpactReport(companyID uint, startDate, endDate time.Time) (report *model.CompanyImpactReport, err error) {
+    s, span := s.startSpan("Service.GenerateCompanyImpactReport")
+    defer func() { tracing.End(span, err) }()
+    if !endDate.After(startDate) {
+        return nil, fmt.Errorf("end_date must be after start_date")
+    }
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    current, err := s.repo.GetCompanyImpactMetrics(companyID, startDate, endDate)
+    if err != nil {
+        return nil, err
+    }
+    previous, err := s.repo.GetCompanyImpactMetrics(companyID, startDate.AddDate(-1, 0, 0), endDate.AddDate(-1, 0, 0))
+    if err != nil {
+        return nil, err
+    }
+    trend := model.ImpactTrend{
+        VolunteerHours:      percentChange(current.VolunteerHours, previous.VolunteerHours),
+        MatchesCompleted:    percentChange(float64(current.MatchesCompleted), float64(previous.MatchesCompleted)),
+        BeneficiariesServed: percentChange(float64(current.BeneficiariesServed), float64(previous.BeneficiariesServed)),
+    }
+    if current.AverageRating != nil && previous.AverageRating != nil {
+        trend.AverageRating = percentChange(*current.AverageRating, *previous.AverageRating)
+    }
+    return &model.CompanyImpactReport{
+        Company:      *company,
+        PeriodStart:  startDate,
+        PeriodEnd:    endDate,
+        Current:      *current,
+        PreviousYear: *previous,
+        Trend:        trend,
+        GeneratedAt:  time.Now(),
+    }, nil
+}
+
+func formatOptional(v *float64, suffix string) string {
+    if v == nil {
+        return "n/a"
+    }
+    return fmt.Sprintf("%.2f%s", *v, suffix)
+}
+
+// ImpactReportRows flattens a report into metric/current/previous/change rows
+// shared by the CSV and PDF downloads.
+func ImpactReportRows(report *model.CompanyImpactReport) [][]string {
+    cur, prev, trend := report.Current, report.PreviousYear, report.Trend
+    rows := [][]string{
+        {"Volunteer hours", fmt.Sprintf("%.2f", cur.VolunteerHours), fmt.Sprintf("%.2f", prev.VolunteerHours), formatOptional(trend.VolunteerHours, "%")},
+        {"Matches completed", fmt.Sprint(cur.MatchesCompleted), fmt.Sprint(prev.MatchesCompleted), formatOptional(trend.MatchesCompleted, "%")},
+        {"Beneficiaries served", fmt.Sprint(cur.BeneficiariesServed), fmt.Sprint(prev.BeneficiariesServed), formatOptional(trend.BeneficiariesServed, "%")},
+        {"Active volunteers", fmt.Sprint(cur.ActiveVolunteers), fmt.Sprint(prev.ActiveVolunteers), ""},
+        {"Average rating", formatOptional(cur.AverageRating, ""), formatOptional(prev.AverageRating, ""), formatOptional(trend.AverageRating, "%")},
+    }
+    for _, c := range cur.ByCategory {
+        rows = append(rows, []string{
+            "Category: " + c.CategoryName + " - matches completed", fmt.Sprint(c.MatchesCompleted), "", "",
+        }, []string{
+            "Category: " + c.CategoryName + " - volunteer hours", fmt.Sprintf("%.2f", c.VolunteerHours), "", "",
+        })
+    }
+    return rows
+}
+
+// Scheduled reports
+
+const reportSchedulerLockKey int64 = 0x4353525f52505453 // "CSR_RPTS"
+
+var ReportTypes = []string{"daily", "weekly", "monthly"}
+
+// ReportPeriod returns the bounds [start, end) and period key of the report
+// period of the given type that contains t. Periods are computed in UTC;
+// weeks start on Monday and use ISO week numbering.
+func ReportPeriod(reportType string, t time.Time) (time.Time, time.Time, string, error) {
+    t = t.UTC()
+    day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+    switch reportType {
+    case "daily":
+        return day, day.AddDate(0, 0, 1), day.Format("2006-01-02"), nil
+    case "weekly":
+        start := day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+        year, week := start.ISOWeek()
+        return start, start.AddDate(0, 0, 7), fmt.Sprintf("%d-W%02d", year, week), nil
+    case "monthly":
+        start := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+        return start, start.AddDate(0, 1, 0), start.Format("2006-01"), nil
+    }
+    return time.Time{}, time.Time{}, "", fmt.Errorf("unknown report type %q", reportType)
+}
+
+// GenerateDueReports creates any missing reports of reportType for the most
+// recent completed periods of every active tenant, up to the configured
+// backfill limit. It is safe to call repeatedly and from several instances:
+// existing (tenant, type, period) reports are skipped and a DB advisory lock
+// ensures only one instance generates at a time. It returns the number of
+// reports created.
+func (s *Service) GenerateDueReports(ctx context.Context, reportType string, now time.Time) (created int, err error) {
+    s, span := s.WithContext(ctx).startSpan("Service.GenerateDueReports")
+    defer func() { tracing.End(span, err) }()
+    type period struct {
+        start, end time.Time
+        key        string
+    }
+    limit := s.cfg.ReportBackfillLimit
+    if limit < 1 {
+        limit = 1
+    }
+    current, _, _, err := ReportPeriod(reportType, now)
+    if err != nil {
+        return 0, err
+    }
+    var periods []period
+    var keys []string
+    cursor := current.Add(-time.Nanosecond)
+    for i := 0; i < limit; i++ {
+        start, end, key, _ := ReportPeriod(reportType, cursor)
+        periods = append(periods, period{start, end, key})
+        keys = append(keys, key)
+        cursor = start.Add(-time.Nanosecond)
+    }
+
+    _, err = s.repo.WithAdvisoryLock(reportSchedulerLockKey, func(repo *repository.Repository) error {
+        tenants, err := repo.ListTenants()
+        if err != nil {
+            return err
+        }
+        for _, tenant := range tenants {
+            if !tenant.IsActive {
+                continue
+            }
+            tenantRepo := repo.WithContext(tenancy.WithTenant(s.ctx, tenant.ID))
+            existing, err := tenantRepo.GetExistingReportPeriods(reportType, keys)
+            if err != nil {
+                return err
+            }
+            // Oldest first. All periods are created in one transaction, so a
+            // failure leaves none of them and the next run starts over.
+            for i := len(periods) - 1; i >= 0; i-- {
+                p := periods[i]
+                if existing[p.key] {
+                    continue
+                }
+                stats, err := tenantRepo.GetRequestStats(p.start, p.end.Add(-time.Nanosecond))
+                if err != nil {
+                    return err
+                }
+                data, err := json.Marshal(stats)
+                if err != nil {
+                    return err
+                }
+                report := &model.Report{ReportType: reportType, Period: p.key, Data: string(data), GeneratedAt: time.Now()}
+                ok, err := tenantRepo.CreateReportIfMissing(report)
+                if err != nil {
+                    return err
+                }
+                if ok {
+                    created++
+                }
+            }
+        }
+        return nil
+    })
+    return created, err
+}
+
+// Engagement analytics
+
+func fillFunnelRates(row *model.FunnelRow) {
+    if row.Requests == 0 {
+        return
+    }
+    n := float64(row.Requests)
+    row.ViewRate = float64(row.Viewed) / n
+    row.ShortlistRate = float64(row.Shortlisted) / n
+    row.MatchRate = float64(row.Matched) / n
+    row.CompletionRate = float64(row.Completed) / n
+}
+
+func (s *Service) GetEngagementFunnel(filter model.FunnelFilter) (funnel *model.FunnelResponse, err error) {
+    s, span := s.startSpan("Service.GetEngagementFunnel")
+    defer func() { tracing.End(span, err) }()
+    if !filter.EndDate.After(filter.StartDate) {
+        return nil, fmt.Errorf("end_date must be after start_date")
+    }
+    if filter.GroupBy == "time" && filter.Bucket == "" {
+        filter.Bucket = "week"
+    }
+    rows, err := s.repo.GetEngagementFunnel(filter)
+    if err != nil {
+        return nil, err
+    }
+    overallFilter := filter
+    overallFilter.GroupBy = ""
+    overall, err := s.repo.GetEngagementFunnel(overallFilter)
+    if err != nil {
+        return nil, err
+    }
+    response := &model.FunnelResponse{Filter: filter, Rows: rows}
+    if len(overall) > 0 {
+        response.Overall = overall[0]
+    }
+    fillFunnelRates(&response.Overall)
+    for i := range response.Rows {
+        fillFunnelRates(&response.Rows[i])
+    }
+    return response, nil
+}
+
+// Request view tracking
+
+type viewKey struct{ csrRepID, requestID uint }
+
+// viewTracker de-duplicates request views per rep and writes view logs in
+// the background so request detail reads never wait on an insert.
+// De-duplication is in memory and per process: each API instance counts a
+// rep's first view in the window, and a restart forgets recent views.
+type viewTracker struct {
+    repo          *repository.Repository
+    window        time.Duration
+    batchSize     int
+    flushInterval time.Duration
+    queue         chan model.ViewLog
+
+    mu   sync.Mutex
+    seen map[viewKey]time.Time
+}
+
+const defaultViewFlushInterval = 5 * time.Second
+
+func newViewTracker(repo *repository.Repository, cfg *config.Config) *viewTracker {
+    batchSize := cfg.ViewLogBatchSize
+    if batchSize < 1 {
+        batchSize = 1
+    }
+    flushInterval := cfg.ViewLogFlushInterval
+    if flushInterval <= 0 {
+        flushInterval = defaultViewFlushInterval
+    }
+    return &viewTracker{
+        repo:          repo,
+        window:        cfg.ViewDedupWindow,
+        batchSize:     batchSize,
+        flushInterval: flushInterval,
+        queue:         make(chan model.ViewLog, batchSize*10),
+        seen:          make(map[viewKey]time.Time),
+    }
+}
+
+// track queues a view unless the same rep already viewed the request within
+// the de-duplication window. It reports whether the view was counted. A view
+// dropped because the queue is full is not marked seen, so the next one
+// counts.
+func (t *viewTracker) track(v model.ViewLog) bool {
+    key := viewKey{v.CSRRepID, v.RequestID}
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    if last, ok := t.seen[key]; ok && v.CreatedAt.Sub(last) < t.window {
+        return false
+    }
+    select {
+    case t.queue <- v:
+        t.seen[key] = v.CreatedAt
+        return true
+    default:
+        slog.Warn("view tracker queue full, dropping view", "request_id", v.RequestID, "csr_rep_id", v.CSRRepID)
+        return false
+    }
+}
+
+func (t *viewTracker) flush(batch []model.ViewLog) {
+    if err := t.repo.RecordViews(batch); err != nil {
+        slog.Error("view tracker failed to write view logs", "count", len(batch), "error", err)
+    }
+}
+
+// prune forgets views older than the window so the map stays bounded.
+func (t *viewTracker) prune(now time.Time) {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    for key, last := range t.seen {
+        if now.Sub(last) >= t.window {
+            delete(t.seen, key)
+        }
+    }
+}
+
+func (t *viewTracker) run(done <-chan struct{}) {
+    ticker := time.NewTicker(t.flushInterval)
+    defer ticker.Stop()
+    batch := make([]model.ViewLog, 0, t.batchSize)
+    for {
+        select {
+        case v := <-t.queue:
+            batch = append(batch, v)
+            if len(batch) >= t.batchSize {
+                t.flush(batch)
+                batch = batch[:0]
+            }
+        case now := <-ticker.C:
+            if len(batch) > 0 {
+                t.flush(batch)
+                batch = batch[:0]
+            }
+            t.prune(now)
+        case <-done:
+            // Drain whatever is still queued before returning.
+            for {
+                select {
+                case v := <-t.queue:
+                    batch = append(batch, v)
+                default:
+                    t.flush(batch)
+                    return
+                }
+            }
+        }
+    }
+}
+
+// RunViewTracker writes queued view logs until ctx is cancelled, then flushes
+// the remainder and returns.
+func (s *Service) RunViewTracker(ctx context.Context) { s.views.run(ctx.Done()) }
+
+// ViewPINRequest loads a request for a CSR rep and records the view.
+func (s *Service) ViewPINRequest(user *model.User, requestID uint, ipAddress, userAgent string) (request *model.PINRequest, err error) {
+    s, span := s.startSpan("Service.ViewPINRequest")
+    defer func() { tracing.End(span, err) }()
+    csrRep, err := s.repo.GetCSRRepByUserID(user.ID)
+    if err != nil {
+        return nil, fmt.Errorf("CSR profile not found")
+    }
+    request, err = s.repo.GetPINRequestByID(requestID)
+    if err != nil || request.ModerationStatus != model.ModerationApproved {
+        return nil, ErrRequestNotFound
+    }
+    blocked, err := s.repo.IsBlocked(user.ID, request.PIN.UserID)
+    if err != nil {
+        return nil, err
+    }
+    if blocked {
+        return nil, ErrRequestNotFound
+    }
+    // Impersonating admins leave no views behind; views are flushed in the
+    // background, outside the request's tenant.
+    if s.impersonating() {
+        return request, nil
+    }
+    s.views.track(model.ViewLog{
+        TenantID:  request.TenantID,
+        CreatedAt: time.Now(),
+        CSRRepID:  csrRep.ID,
+        RequestID: request.ID,
+        IPAddress: ipAddress,
+        UserAgent: userAgent,
+    })
+    return request, nil
+}
+
+// Health
+
+// Readiness reports whether the service can take traffic: the database must
+// answer a ping and carry at least the schema version this build expects.
+func (s *Service) Readiness(ctx context.Context) (map[string]interface{}, bool) {
+    status := map[string]interface{}{"expected_schema_version": repository.SchemaVersion}
+    if err := s.repo.Ping(ctx); err != nil {
+        status["database"] = err.Error()
+        return status, false
+    }
+    status["database"] = "ok"
+    version, err := s.repo.GetSchemaVersion(ctx)
+    if err != nil {
+        status["schema_version"] = err.Error()
+        return status, false
+    }
+    status["schema_version"] = version
+    return status, version >= repository.SchemaVersion
+}
+
+// Login lockout
+
+// ErrInvalidCredentials is the only error a failed login reports, whether the
+// username is unknown, the password wrong or the account locked.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// NormalizeUsername is the form of a username used for lookups and for
+// per-username rate limiting.
+func NormalizeUsername(username string) string {
+    return strings.ToLower(strings.TrimSpace(username))
+}
+
+// LoginLockout returns how much longer the named account is locked, or zero.
+// Unknown usernames are never locked.
+func (s *Service) LoginLockout(username string) time.Duration {
+    user, err := s.repo.GetUserByUsername(NormalizeUsername(username))
+    if err != nil || user.LockedUntil == nil {
+        return 0
+    }
+    if wait := time.Until(*user.LockedUntil); wait > 0 {
+        return wait
+    }
+    return 0
+}
+
+// RecordLoginFailure counts a failed login against the account and locks it
+// once the threshold is reached. Each failure past the threshold doubles the
+// lock duration, up to the configured maximum.
+func (s *Service) RecordLoginFailure(username string) error {
+    user, err := s.repo.GetUserByUsername(NormalizeUsername(username))
+    if err != nil {
+        return nil
+    }
+    now := time.Now()
+    attempts, err := s.repo.IncrementFailedLogins(user.ID, now)
+    if err != nil || s.cfg.LockoutThreshold <= 0 || attempts < s.cfg.LockoutThreshold {
+        return err
+    }
+    lock := s.cfg.LockoutBaseDuration
+    for i := s.cfg.LockoutThreshold; i < attempts && lock < s.cfg.LockoutMaxDuration; i++ {
+        lock *= 2
+    }
+    if lock > s.cfg.LockoutMaxDuration {
+        lock = s.cfg.LockoutMaxDuration
+    }
+    return s.repo.LockUser(user.ID, now.Add(lock))
+}
+
+func (s *Service) RecordLoginSuccess(userID uint) error {
+    return s.repo.ResetFailedLogins(userID)
+}
+
+// Emailed tokens: password reset and email verification
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// signToken returns the HMAC of payload under the token secret, bound to
+// purpose so a token issued for one flow cannot be replayed in another.
+func (s *Service) signToken(purpose string, payload []byte) []byte {
+    mac := hmac.New(sha256.New, []byte(s.cfg.TokenSecret))
+    mac.Write([]byte(purpose))
+    mac.Write([]byte{0})
+    mac.Write(payload)
+    return mac.Sum(nil)
+}
+
+func hashToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// issueAuthToken creates a signed single-use token of the form
+// <random>.<signature>; only its hash is stored.
+func (s *Service) issueAuthToken(userID uint, purpose string, ttl time.Duration, payload string) (string, error) {
+    raw := make([]byte, 32)
+    if _, err := rand.Read(raw); err != nil {
+        return "", err
+    }
+    enc := base64.RawURLEncoding
+    token := enc.EncodeToString(raw) + "." + enc.EncodeToString(s.signToken(purpose, raw))
+    record := &model.AuthToken{UserID: userID, Purpose: purpose, TokenHash: hashToken(token), Payload: payload, ExpiresAt: time.Now().Add(ttl)}
+    if err := s.repo.CreateAuthToken(record); err != nil {
+        return "", err
+    }
+    return token, nil
+}
+
+// verifyTokenSignature checks a token's signature without touching the database.
+func (s *Service) verifyTokenSignature(token, purpose string) bool {
+    parts := strings.Split(token, ".")
+    if len(parts) != 2 {
+        return false
+    }
+    enc := base64.RawURLEncoding
+    raw, err := enc.DecodeString(parts[0])
+    if err != nil {
+        return false
+    }
+    sig, err := enc.DecodeString(parts[1])
+    return err == nil && hmac.Equal(sig, s.signToken(purpose, raw))
+}
+
+// consumeAuthToken checks the signature before touching the database, then
+// atomically marks the token used. Emailed links do not name a tenant, so
+// the token is looked up across tenants and callers continue in its tenant.
+func (s *Service) consumeAuthToken(token, purpose string) (*model.AuthToken, error) {
+    if !s.verifyTokenSignature(token, purpose) {
+        return nil, ErrInvalidToken
+    }
+    record, err := s.Unscoped().repo.ConsumeAuthToken(hashToken(token), purpose, time.Now())
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    return record, nil
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an
+// active account. It succeeds either way so callers cannot probe for emails.
+func (s *Service) RequestPasswordReset(email string) error {
+    user, err := s.repo.GetUserByEmail(email)
+    if err != nil || !user.IsActive {
+        return nil
+    }
+    return s.sendPasswordReset(user)
+}
+
+func (s *Service) sendPasswordReset(user *model.User) error {
+    token, err := s.issueAuthToken(user.ID, model.TokenPurposePasswordReset, s.cfg.PasswordResetTTL, "")
+    if err != nil {
+        return err
+    }
+    return s.mailer.Send(s.ctx, mailer.Message{
+        To:      user.Email,
+        Subject: "Reset your password",
+        Body: fmt.Sprintf("Hello %s,\n\nUse the link below to choose a new password. It expires in %s and can only be used once.\n\n%s/reset-password?token=%s\n\nIf you did not ask for this, you can ignore this email.\n",
+            user.Username, s.cfg.PasswordResetTTL, s.cfg.AppBaseURL, token),
+    })
+}
+
+// ResetPassword sets a new password using a reset token. Any other
+// outstanding reset tokens are invalidated and a lockout is lifted. Since
+// the token arrived by email, the address also counts as verified.
+func (s *Service) ResetPassword(token, password string) error {
+    record, err := s.consumeAuthToken(token, model.TokenPurposePasswordReset)
+    if err != nil {
+        return err
+    }
+    s = s.forTenant(record.TenantID)
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+    if err := s.repo.SetUserPassword(record.UserID, string(hash)); err != nil {
+        return err
+    }
+    return s.repo.InvalidateAuthTokens(record.UserID, model.TokenPurposePasswordReset, time.Now())
+}
+
+// AcceptInvitation sets the invitee's first password. The emailed link
+// proves the address, so it is marked verified too.
+func (s *Service) AcceptInvitation(token, password string) error {
+    record, err := s.consumeAuthToken(token, model.TokenPurposeInvitation)
+    if err != nil {
+        return err
+    }
+    s = s.forTenant(record.TenantID)
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+    if err := s.repo.SetUserPassword(record.UserID, string(hash)); err != nil {
+        return err
+    }
+    now := time.Now()
+    if err := s.repo.MarkEmailVerified(record.UserID, now); err != nil {
+        return err
+    }
+    return s.repo.InvalidateAuthTokens(record.UserID, model.TokenPurposeInvitation, now)
+}
+
+// SendEmailVerification emails a verification link to the user's current
+// address, replacing any link sent earlier.
+func (s *Service) SendEmailVerification(user *model.User) error {
+    if err := s.repo.InvalidateAuthTokens(user.ID, model.TokenPurposeEmailVerification, time.Now()); err != nil {
+        return err
+    }
+    token, err := s.issueAuthToken(user.ID, model.TokenPurposeEmailVerification, s.cfg.EmailVerificationTTL, "")
+    if err != nil {
+        return err
+    }
+    return s.mailer.Send(s.ctx, mailer.Message{
+        To:      user.Email,
+        Subject: "Verify your email address",
+        Body: fmt.Sprintf("Hello %s,\n\nPlease confirm your email address by opening the link below. It expires in %s.\n\n%s/verify-email?token=%s\n",
+            user.Username, s.cfg.EmailVerificationTTL, s.cfg.AppBaseURL, token),
+    })
+}
+
+func (s *Service) VerifyEmail(token string) error {
+    record, err := s.consumeAuthToken(token, model.TokenPurposeEmailVerification)
+    if err != nil {
+        return err
+    }
+    return s.forTenant(record.TenantID).repo.MarkEmailVerified(record.UserID, time.Now())
+}
+
+// Audit log
+
+// RecordAudit stores an audit entry. Failures are logged rather than
+// returned so auditing never blocks the action itself.
+func (s *Service) RecordAudit(actorID *uint, action, targetType string, targetID *uint, details map[string]interface{}, ipAddress string) {
+    data := "{}"
+    if details != nil {
+        if b, err := json.Marshal(details); err == nil {
+            data = string(b)
+        }
+    }
+    entry := &model.AuditLog{ActorID: actorID, Action: action, TargetType: targetType, TargetID: targetID, Details: data, IPAddress: ipAddress}
+    if identity, ok := logging.IdentityFromContext(s.ctx); ok && identity.ImpersonatorID != 0 {
+        entry.ImpersonatorID = &identity.ImpersonatorID
+    }
+    if err := s.repo.CreateAuditLog(entry); err != nil {
+        slog.Error("failed to write audit log", "action", action, "error", err)
+    }
+}
+
+// impersonating reports whether an admin is acting as the request's user.
+func (s *Service) impersonating() bool {
+    identity, ok := logging.IdentityFromContext(s.ctx)
+    return ok && identity.ImpersonatorID != 0
+}
+
+func (s *Service) SearchAuditLogs(filter model.AuditLogFilter, page, pageSize int) (*model.PaginatedResponse, error) {
+    logs, total, err := s.repo.SearchAuditLogs(filter, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: logs,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// Two-factor authentication
+
+const (
+    twoFactorChallengeTTL = 5 * time.Minute
+    recoveryCodeCount     = 10
+)
+
+var (
+    ErrTwoFactorInvalidCode = errors.New("invalid two-factor code")
+    ErrTwoFactorMandatory   = errors.New("two-factor authentication is mandatory for this role")
+    ErrAccountLocked        = errors.New("account temporarily locked, try again later")
+)
+
+// TwoFactorRequired reports whether accounts with role must use 2FA.
+func TwoFactorRequired(role model.UserRole) bool {
+    return role == model.RoleAdmin || role == model.RolePlatform || role == model.RoleSuperAdmin
+}
+
+func (s *Service) secretCipher() (cipher.AEAD, error) {
+    key := sha256.Sum256([]byte("secret-encryption\x00" + s.cfg.TokenSecret))
+    block, err := aes.NewCipher(key[:])
+    if err != nil {
+        return nil, err
+    }
+    return cipher.NewGCM(block)
+}
+
+// sealSecret encrypts values such as TOTP secrets before they are stored.
+func (s *Service) sealSecret(plaintext string) (string, error) {
+    aead, err := s.secretCipher()
+    if err != nil {
+        return "", err
+    }
+    nonce := make([]byte, aead.NonceSize())
+    if _, err := rand.Read(nonce); err != nil {
+        return "", err
+    }
+    return base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+func (s *Service) openSecret(sealed string) (string, error) {
+    aead, err := s.secretCipher()
+    if err != nil {
+        return "", err
+    }
+    data, err := base64.StdEncoding.DecodeString(sealed)
+    if err != nil || len(data) < aead.NonceSize() {
+        return "", fmt.Errorf("malformed secret")
+    }
+    plaintext, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+    return string(plaintext), err
+}
+
+// checkTOTP validates a code against the user's stored secret and records
+// its time step so the same code cannot be used twice.
+func (s *Service) checkTOTP(user *model.User, code string) error {
+    if user.TOTPSecret == "" {
+        return ErrTwoFactorInvalidCode
+    }
+    secret, err := s.openSecret(user.TOTPSecret)
+    if err != nil {
+        return err
+    }
+    step, ok := totp.Validate(secret, code, time.Now(), 1)
+    if !ok {
+        return ErrTwoFactorInvalidCode
+    }
+    fresh, err := s.repo.AdvanceTOTPStep(user.ID, step)
+    if err != nil {
+        return err
+    }
+    if !fresh {
+        return ErrTwoFactorInvalidCode
+    }
+    return nil
+}
+
+func (s *Service) newRecoveryCodes(userID uint) ([]string, error) {
+    codes := make([]string, recoveryCodeCount)
+    hashes := make([]string, recoveryCodeCount)
+    enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+    for i := range codes {
+        b := make([]byte, 5)
+        if _, err := rand.Read(b); err != nil {
+            return nil, err
+        }
+        code := strings.ToLower(enc.EncodeToString(b))
+        codes[i] = code[:4] + "-" + code[4:]
+        hashes[i] = hashToken(codes[i])
+    }
+    if err := s.repo.ReplaceRecoveryCodes(userID, hashes); err != nil {
+        return nil, err
+    }
+    return codes, nil
+}
+
+func normalizeRecoveryCode(code string) string {
+    code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+    if len(code) == 8 {
+        code = code[:4] + "-" + code[4:]
+    }
+    return code
+}
+
+func (s *Service) generateTOTPSecret(user *model.User) (*model.TwoFactorEnrollment, error) {
+    secret, err := totp.GenerateSecret()
+    if err != nil {
+        return nil, err
+    }
+    sealed, err := s.sealSecret(secret)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.repo.SetTOTPSecret(user.ID, sealed); err != nil {
+        return nil, err
+    }
+    return &model.TwoFactorEnrollment{Secret: secret, OTPAuthURI: totp.URI(s.cfg.TwoFactorIssuer, user.Username, secret)}, nil
+}
+
+// BeginTwoFactorLogin is called after a successful password check. If the
+// account needs a second factor it withholds the session token, storing it
+// encrypted behind a short-lived challenge, and returns the challenge. It
+// returns nil when the login can complete immediately.
+func (s *Service) BeginTwoFactorLogin(response *model.LoginResponse) (*model.TwoFactorChallenge, error) {
+    user := response.User
+    enabled := user.TwoFactorEnabledAt != nil
+    if !enabled && !TwoFactorRequired(user.Role) {
+        return nil, nil
+    }
+    sealed, err := s.sealSecret(response.Token)
+    if err != nil {
+        return nil, err
+    }
+    challenge, err := s.issueAuthToken(user.ID, model.TokenPurposeTwoFactorLogin, twoFactorChallengeTTL, sealed)
+    if err != nil {
+        return nil, err
+    }
+    return &model.TwoFactorChallenge{
+        TwoFactorRequired:  true,
+        EnrollmentRequired: !enabled,
+        ChallengeToken:     challenge,
+        ExpiresAt:          time.Now().Add(twoFactorChallengeTTL),
+    }, nil
+}
+
+// loadTwoFactorChallenge looks the challenge up across tenants; callers
+// continue in the challenge's tenant.
+func (s *Service) loadTwoFactorChallenge(challengeToken string) (*model.AuthToken, *model.User, error) {
+    if !s.verifyTokenSignature(challengeToken, model.TokenPurposeTwoFactorLogin) {
+        return nil, nil, ErrInvalidToken
+    }
+    s = s.Unscoped()
+    record, err := s.repo.GetActiveAuthToken(hashToken(challengeToken), model.TokenPurposeTwoFactorLogin, time.Now())
+    if err != nil {
+        return nil, nil, ErrInvalidToken
+    }
+    user, err := s.repo.GetUserByID(record.UserID)
+    if err != nil {
+        return nil, nil, ErrInvalidToken
+    }
+    return record, user, nil
+}
+
+// EnrollTwoFactorForChallenge issues a TOTP secret to an account that must
+// enrol before it can finish logging in.
+func (s *Service) EnrollTwoFactorForChallenge(challengeToken string) (*model.TwoFactorEnrollment, error) {
+    record, user, err := s.loadTwoFactorChallenge(challengeToken)
+    if err != nil {
+        return nil, err
+    }
+    s = s.forTenant(record.TenantID)
+    if user.TwoFactorEnabledAt != nil {
+        return nil, fmt.Errorf("two-factor authentication is already enabled")
+    }
+    return s.generateTOTPSecret(user)
+}
+
+// CompleteTwoFactorLogin checks the TOTP or recovery code for a challenge
+// and releases the withheld session token. For an account enrolling during
+// login, the first valid code also enables 2FA and returns recovery codes.
+func (s *Service) CompleteTwoFactorLogin(req model.TwoFactorVerifyRequest) (*model.TwoFactorLoginResponse, *model.User, error) {
+    record, user, err := s.loadTwoFactorChallenge(req.ChallengeToken)
+    if err != nil {
+        return nil, nil, err
+    }
+    s = s.forTenant(record.TenantID)
+    // Failed codes count towards the same lockout as failed passwords.
+    if s.LoginLockout(user.Username) > 0 {
+        return nil, nil, ErrAccountLocked
+    }
+    enrolling := user.TwoFactorEnabledAt == nil
+    switch {
+    case req.RecoveryCode != "" && !enrolling:
+        ok, err := s.repo.UseRecoveryCode(user.ID, hashToken(normalizeRecoveryCode(req.RecoveryCode)), time.Now())
+        if err != nil {
+            return nil, user, err
+        }
+        if !ok {
+            return nil, user, ErrTwoFactorInvalidCode
+        }
+    case req.Code != "":
+        if err := s.checkTOTP(user, req.Code); err != nil {
+            return nil, user, err
+        }
+    default:
+        return nil, user, ErrTwoFactorInvalidCode
+    }
+
+    if _, err := s.consumeAuthToken(req.ChallengeToken, model.TokenPurposeTwoFactorLogin); err != nil {
+        return nil, user, err
+    }
+    token, err := s.openSecret(record.Payload)
+    if err != nil {
+        return nil, user, err
+    }
+    response := &model.TwoFactorLoginResponse{}
+    if enrolling {
+        now := time.Now()
+        if err := s.repo.EnableTwoFactor(user.ID, now); err != nil {
+            return nil, user, err
+        }
+        user.TwoFactorEnabledAt = &now
+        if response.RecoveryCodes, err = s.newRecoveryCodes(user.ID); err != nil {
+            return nil, user, err
+        }
+    }
+    response.Token = token
+    response.User = *user
+    return response, user, nil
+}
+
+// StartTwoFactorEnrollment issues a new TOTP secret to a logged-in user who
+// has not enabled 2FA yet. It takes effect once confirmed with a code.
+func (s *Service) StartTwoFactorEnrollment(user *model.User) (*model.TwoFactorEnrollment, error) {
+    if user.TwoFactorEnabledAt != nil {
+        return nil, fmt.Errorf("two-factor authentication is already enabled")
+    }
+    return s.generateTOTPSecret(user)
+}
+
+func (s *Service) ConfirmTwoFactorEnrollment(user *model.User, code string) ([]string, error) {
+    if user.TwoFactorEnabledAt != nil {
+        return nil, fmt.Errorf("two-factor authentication is already enabled")
+    }
+    if err := s.checkTOTP(user, code); err != nil {
+        return nil, err
+    }
+    if err := s.repo.EnableTwoFactor(user.ID, time.Now()); err != nil {
+        return nil, err
+    }
+    return s.newRecoveryCodes(user.ID)
+}
+
+func (s *Service) DisableTwoFactor(user *model.User, code string) error {
+    if TwoFactorRequired(user.Role) {
+        return ErrTwoFactorMandatory
+    }
+    if user.TwoFactorEnabledAt == nil {
+        return fmt.Errorf("two-factor authentication is not enabled")
+    }
+    if err := s.checkTOTP(user, code); err != nil {
+        return err
+    }
+    return s.repo.DisableTwoFactor(user.ID)
+}
+
+func (s *Service) RegenerateRecoveryCodes(user *model.User, code string) ([]string, error) {
+    if user.TwoFactorEnabledAt == nil {
+        return nil, fmt.Errorf("two-factor authentication is not enabled")
+    }
+    if err := s.checkTOTP(user, code); err != nil {
+        return nil, err
+    }
+    return s.newRecoveryCodes(user.ID)
+}
+
+// AdminResetTwoFactor clears another user's 2FA so they can enrol again, for
+// example after losing their device and recovery codes. The reset is audited.
+func (s *Service) AdminResetTwoFactor(admin *model.User, userID uint, ipAddress string) error {
+    target, err := s.repo.GetUserByID(userID)
+    if err != nil {
+        return fmt.Errorf("user not found")
+    }
+    if err := s.repo.DisableTwoFactor(target.ID); err != nil {
+        return err
+    }
+    s.RecordAudit(&admin.ID, "user.2fa_reset", "user", &target.ID, map[string]interface{}{"username": target.Username}, ipAddress)
+    return nil
+}
+
+// Sessions
+
+// sessionTokenPrefix marks opaque server-side session tokens, issued for
+// single sign-on, so AuthMiddleware can tell them apart from JWTs.
+const sessionTokenPrefix = "sess_"
+
+func IsSessionToken(token string) bool {
+    return strings.HasPrefix(token, sessionTokenPrefix)
+}
+
+func (s *Service) issueSessionToken(user *model.User) (string, error) {
+    token, err := s.issueAuthToken(user.ID, model.TokenPurposeSession, s.cfg.SSOSessionTTL, "")
+    if err != nil {
+        return "", err
+    }
+    return sessionTokenPrefix + token, nil
+}
+
+// ValidateSessionToken returns the active user owning a session token.
+func (s *Service) ValidateSessionToken(token string) (*model.User, error) {
+    token = strings.TrimPrefix(token, sessionTokenPrefix)
+    if !s.verifyTokenSignature(token, model.TokenPurposeSession) {
+        return nil, ErrInvalidToken
+    }
+    record, err := s.repo.GetActiveAuthToken(hashToken(token), model.TokenPurposeSession, time.Now())
+    if err != nil {
+        return nil, ErrInvalidToken
+    }
+    user, err := s.repo.GetUserByID(record.UserID)
+    if err != nil || !user.IsActive {
+        return nil, ErrInvalidToken
+    }
+    return user, nil
+}
+
+// Impersonation
+
+// impersonationTokenPrefix marks impersonation tokens, which act as the
+// target user on behalf of an admin.
+const impersonationTokenPrefix = "imp_"
+
+// impersonatableRoles are the roles support may impersonate. Accounts with
+// administrative access never can be.
+var impersonatableRoles = map[model.UserRole]bool{
+    model.RolePIN:          true,
+    model.RoleCSRRep:       true,
+    model.RoleCompanyAdmin: true,
+}
+
+type impersonationPayload struct {
+    AdminID    uint   `json:"admin_id"`
+    Reason     string `json:"reason"`
+    AllowWrite bool   `json:"allow_write"`
+}
+
+func IsImpersonationToken(token string) bool {
+    return strings.HasPrefix(token, impersonationTokenPrefix)
+}
+
+// StartImpersonation issues a short-lived token acting as the target user.
+// It is read-only unless the admin asks for write access.
+func (s *Service) StartImpersonation(admin *model.User, targetID uint, req model.ImpersonateRequest, ipAddress string) (*model.ImpersonationResponse, error) {
+    if admin.ID == targetID {
+        return nil, fmt.Errorf("you cannot impersonate yourself")
+    }
+    target, err := s.repo.GetUserByID(targetID)
+    if err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    if !impersonatableRoles[target.Role] {
+        return nil, fmt.Errorf("%s accounts cannot be impersonated", target.Role)
+    }
+    if !target.IsActive {
+        return nil, fmt.Errorf("user is deactivated")
+    }
+    payload, err := json.Marshal(impersonationPayload{AdminID: admin.ID, Reason: req.Reason, AllowWrite: req.AllowWrite})
+    if err != nil {
+        return nil, err
+    }
+    token, err := s.issueAuthToken(target.ID, model.TokenPurposeImpersonation, s.cfg.ImpersonationTTL, string(payload))
+    if err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "user.impersonation_started", "user", &target.ID, map[string]interface{}{
+        "reason": req.Reason, "allow_write": req.AllowWrite,
+    }, ipAddress)
+    return &model.ImpersonationResponse{
+        Token:     impersonationTokenPrefix + token,
+        ExpiresAt: time.Now().Add(s.cfg.ImpersonationTTL),
+        ReadOnly:  !req.AllowWrite,
+        User:      *target,
+    }, nil
+}
+
+// ValidateImpersonationToken returns the impersonated user and the admin
+// behind the token. The admin must still be active and allowed to
+// impersonate. If the impersonated account has since been deactivated or
+// deleted, the session ends and cannot resume when the account returns.
+func (s *Service) ValidateImpersonationToken(token string) (*model.User, *model.Impersonation, error) {
+    token = strings.TrimPrefix(token, impersonationTokenPrefix)
+    if !s.verifyTokenSignature(token, model.TokenPurposeImpersonation) {
+        return nil, nil, ErrInvalidToken
+    }
+    record, err := s.repo.GetActiveAuthToken(hashToken(token), model.TokenPurposeImpersonation, time.Now())
+    if err != nil {
+        return nil, nil, ErrInvalidToken
+    }
+    var payload impersonationPayload
+    if err := json.Unmarshal([]byte(record.Payload), &payload); err != nil {
+        return nil, nil, ErrInvalidToken
+    }
+    admin, err := s.repo.GetUserByID(payload.AdminID)
+    if err != nil || !admin.IsActive || !s.policy.Allows(admin.Role, policy.UserImpersonate) {
+        return nil, nil, ErrInvalidToken
+    }
+    user, err := s.repo.GetUserByID(record.UserID)
+    if impersonationTargetGone(user, err) {
+        if _, err := s.repo.ConsumeAuthToken(record.TokenHash, model.TokenPurposeImpersonation, time.Now()); err == nil {
+            s.RecordAudit(&payload.AdminID, "user.impersonation_ended", "user", &record.UserID, map[string]interface{}{
+                "reason": "target_inactive",
+            }, "")
+        }
+        return nil, nil, ErrInvalidToken
+    }
+    if err != nil || !impersonatableRoles[user.Role] {
+        return nil, nil, ErrInvalidToken
+    }
+    return user, &model.Impersonation{
+        AdminID:       admin.ID,
+        AdminUsername: admin.Username,
+        Reason:        payload.Reason,
+        ReadOnly:      !payload.AllowWrite,
+        ExpiresAt:     record.ExpiresAt,
+    }, nil
+}
+
+// impersonationTargetGone reports whether the impersonated account, looked
+// up with err, has been deleted or deactivated.
+func impersonationTargetGone(user *model.User, err error) bool {
+    return errors.Is(err, gorm.ErrRecordNotFound) || err == nil && !user.IsActive
+}
+
+// EndImpersonation invalidates an impersonation token before it expires.
+func (s *Service) EndImpersonation(token, ipAddress string) error {
+    record, err := s.consumeAuthToken(strings.TrimPrefix(token, impersonationTokenPrefix), model.TokenPurposeImpersonation)
+    if err != nil {
+        return err
+    }
+    var payload impersonationPayload
+    if err := json.Unmarshal([]byte(record.Payload), &payload); err != nil {
+        return ErrInvalidToken
+    }
+    s.forTenant(record.TenantID).RecordAudit(&payload.AdminID, "user.impersonation_ended", "user", &record.UserID, nil, ipAddress)
+    return nil
+}
+
+type jwtClaims struct {
+    TenantID uint  `json:"tenant_id"`
+    IssuedAt int64 `json:"iat"`
+}
+
+// parseJWTClaims decodes the claims of a JWT without verifying it; call it
+// only after ValidateToken. Other tokens yield empty claims.
+func parseJWTClaims(token string) jwtClaims {
+    var claims jwtClaims
+    parts := strings.Split(token, ".")
+    if len(parts) != 3 {
+        return claims
+    }
+    payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+    if err == nil {
+        _ = json.Unmarshal(payload, &claims)
+    }
+    return claims
+}
+
+// TokenTenantClaim returns the tenant_id claim of a JWT, or 0 if it has none.
+func TokenTenantClaim(token string) uint {
+    return parseJWTClaims(token).TenantID
+}
+
+// SessionRevoked reports whether a JWT was issued no later than the user's
+// sessions were revoked. Once sessions have been revoked, a JWT without an
+// iat claim counts as revoked since its age is unknown. API keys, SSO
+// sessions and impersonation tokens are revoked in the database.
+func SessionRevoked(user *model.User, token string) bool {
+    if user.SessionsRevokedAt == nil || IsAPIKey(token) || IsSessionToken(token) || IsImpersonationToken(token) {
+        return false
+    }
+    issuedAt := parseJWTClaims(token).IssuedAt
+    return issuedAt == 0 || !time.Unix(issuedAt, 0).After(*user.SessionsRevokedAt)
+}
+
+// Single sign-on
+
+const ssoStateTTL = 10 * time.Minute
+
+var (
+    ErrSSONotConfigured = errors.New("single sign-on is not configured for this company")
+    ErrSSOFailed        = errors.New("single sign-on failed")
+    // ErrSSODenied means the identity was verified but may not sign in.
+    ErrSSODenied = errors.New("single sign-on is not allowed for this account")
+    // ErrSSOConflict means the identity clashes with an existing account.
+    ErrSSOConflict    = errors.New("single sign-on conflicts with an existing account")
+    ErrSSODomainTaken = repository.ErrSSODomainTaken
+)
+
+// oidcProviders caches provider discovery documents and signing keys by issuer.
+type oidcProviders struct {
+    mu       sync.Mutex
+    byIssuer map[string]*oidc.Provider
+}
+
+// ssoState travels through the browser in a sealed cookie between the
+// redirect to the identity provider and the callback.
+type ssoState struct {
+    TenantID  uint      `json:"tenant_id"`
+    CompanyID uint      `json:"company_id"`
+    State     string    `json:"state"`
+    Nonce     string    `json:"nonce"`
+    Verifier  string    `json:"verifier"`
+    ExpiresAt time.Time `json:"expires_at"`
+}
+
+type ssoClaims struct {
+    Email         string `json:"email"`
+    EmailVerified bool   `json:"email_verified"`
+    Name          string `json:"name"`
+    GivenName     string `json:"given_name"`
+    FamilyName    string `json:"family_name"`
+}
+
+func randomString(n int) (string, error) {
+    b := make([]byte, n)
+    if _, err := rand.Read(b); err != nil {
+        return "", err
+    }
+    return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func normalizeEmailDomains(domains []string) []string {
+    var out []string
+    for _, d := range domains {
+        d = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(d), "@"))
+        if d != "" {
+            out = append(out, d)
+        }
+    }
+    return out
+}
+
+func emailDomain(email string) string {
+    at := strings.LastIndex(email, "@")
+    if at < 0 {
+        return ""
+    }
+    return strings.ToLower(email[at+1:])
+}
+
+func (s *Service) oidcProvider(issuer string) (*oidc.Provider, error) {
+    s.oidc.mu.Lock()
+    defer s.oidc.mu.Unlock()
+    if p, ok := s.oidc.byIssuer[issuer]; ok {
+        return p, nil
+    }
+    p, err := oidc.NewProvider(s.ctx, issuer)
+    if err != nil {
+        return nil, err
+    }
+    s.oidc.byIssuer[issuer] = p
+    return p, nil
+}
+
+func (s *Service) ssoOAuthConfig(config *model.CompanySSOConfig) (*oidc.Provider, *oauth2.Config, error) {
+    provider, err := s.oidcProvider(config.Issuer)
+    if err != nil {
+        return nil, nil, fmt.Errorf("identity provider discovery failed: %w", err)
+    }
+    secret := ""
+    if config.ClientSecret != "" {
+        if secret, err = s.openSecret(config.ClientSecret); err != nil {
+            return nil, nil, err
+        }
+    }
+    return provider, &oauth2.Config{
+        ClientID:     config.ClientID,
+        ClientSecret: secret,
+        Endpoint:     provider.Endpoint(),
+        RedirectURL:  s.cfg.AppBaseURL + "/auth/sso/callback",
+        Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+    }, nil
+}
+
+func (s *Service) GetCompanySSOConfig(companyID uint) (*model.CompanySSOConfig, error) {
+    config, err := s.repo.GetCompanySSOConfig(companyID)
+    if err != nil {
+        return nil, ErrSSONotConfigured
+    }
+    return config, nil
+}
+
+// SaveCompanySSOConfig creates or replaces a company's OIDC settings. An
+// empty client secret keeps the stored one, so admins need not re-enter it.
+func (s *Service) SaveCompanySSOConfig(admin *model.User, companyID uint, req model.CompanySSORequest, ipAddress string) (*model.CompanySSOConfig, error) {
+    if _, err := s.repo.GetCompanyByID(companyID); err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    domains := normalizeEmailDomains(req.AllowedEmailDomains)
+    if len(domains) == 0 {
+        return nil, fmt.Errorf("at least one allowed email domain is required")
+    }
+    config, err := s.repo.GetCompanySSOConfig(companyID)
+    if err != nil {
+        config = &model.CompanySSOConfig{CompanyID: companyID, Enabled: true}
+    }
+    config.Issuer = req.Issuer
+    config.ClientID = req.ClientID
+    config.AllowedEmailDomains = strings.Join(domains, ",")
+    if req.Enabled != nil {
+        config.Enabled = *req.Enabled
+    }
+    if req.ClientSecret != "" {
+        if config.ClientSecret, err = s.sealSecret(req.ClientSecret); err != nil {
+            return nil, err
+        }
+    }
+    if err := s.repo.SaveCompanySSOConfig(config); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "company.sso_updated", "company", &companyID, map[string]interface{}{
+        "issuer": config.Issuer, "client_id": config.ClientID, "enabled": config.Enabled,
+    }, ipAddress)
+    return config, nil
+}
+
+func (s *Service) DeleteCompanySSOConfig(admin *model.User, companyID uint, ipAddress string) error {
+    if err := s.repo.DeleteCompanySSOConfig(companyID); err != nil {
+        return err
+    }
+    s.RecordAudit(&admin.ID, "company.sso_deleted", "company", &companyID, nil, ipAddress)
+    return nil
+}
+
+// DiscoverSSO returns the company whose SSO configuration accepts email.
+func (s *Service) DiscoverSSO(email string) (*model.CompanySSOConfig, error) {
+    domain := emailDomain(email)
+    if domain == "" {
+        return nil, ErrSSONotConfigured
+    }
+    config, err := s.repo.FindCompanySSOConfigByDomain(domain)
+    if err != nil {
+        return nil, ErrSSONotConfigured
+    }
+    return config, nil
+}
+
+// BeginSSOLogin returns the identity provider URL to redirect the browser
+// to, and the sealed state the caller must store in a cookie.
+func (s *Service) BeginSSOLogin(companyID uint) (string, string, error) {
+    config, err := s.repo.GetCompanySSOConfig(companyID)
+    if err != nil || !config.Enabled {
+        return "", "", ErrSSONotConfigured
+    }
+    _, oauthConfig, err := s.ssoOAuthConfig(config)
+    if err != nil {
+        return "", "", err
+    }
+    tenantID, _ := tenancy.FromContext(s.ctx)
+    state := ssoState{TenantID: tenantID, CompanyID: companyID, Verifier: oauth2.GenerateVerifier(), ExpiresAt: time.Now().Add(ssoStateTTL)}
+    if state.State, err = randomString(24); err != nil {
+        return "", "", err
+    }
+    if state.Nonce, err = randomString(24); err != nil {
+        return "", "", err
+    }
+    data, err := json.Marshal(state)
+    if err != nil {
+        return "", "", err
+    }
+    sealed, err := s.sealSecret(string(data))
+    if err != nil {
+        return "", "", err
+    }
+    url := oauthConfig.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+    return url, sealed, nil
+}
+
+// CompleteSSOLogin handles the identity provider's callback: it checks the
+// state, exchanges the code, verifies the ID token and returns a session for
+// the linked user, provisioning one on first login.
+func (s *Service) CompleteSSOLogin(sealedState, state, code string) (login *model.LoginResponse, err error) {
+    s, span := s.startSpan("Service.CompleteSSOLogin")
+    defer func() { tracing.End(span, err) }()
+
+    var pending ssoState
+    plain, err := s.openSecret(sealedState)
+    if err != nil || json.Unmarshal([]byte(plain), &pending) != nil {
+        return nil, fmt.Errorf("%w: missing or invalid state", ErrSSOFailed)
+    }
+    if !hmac.Equal([]byte(pending.State), []byte(state)) || time.Now().After(pending.ExpiresAt) {
+        return nil, fmt.Errorf("%w: state mismatch or expired", ErrSSOFailed)
+    }
+    // The callback URL is shared by every tenant; the state names the one
+    // the login started in.
+    s = s.forTenant(pending.TenantID)
+    config, err := s.repo.GetCompanySSOConfig(pending.CompanyID)
+    if err != nil || !config.Enabled {
+        return nil, ErrSSONotConfigured
+    }
+    provider, oauthConfig, err := s.ssoOAuthConfig(config)
+    if err != nil {
+        return nil, err
+    }
+    oauthToken, err := oauthConfig.Exchange(s.ctx, code, oauth2.VerifierOption(pending.Verifier))
+    if err != nil {
+        slog.Warn("sso code exchange failed", "company_id", config.CompanyID, "error", err)
+        return nil, fmt.Errorf("%w: code exchange failed", ErrSSOFailed)
+    }
+    rawIDToken, ok := oauthToken.Extra("id_token").(string)
+    if !ok {
+        return nil, fmt.Errorf("%w: no id_token in response", ErrSSOFailed)
+    }
+    idToken, err := provider.Verifier(&oidc.Config{ClientID: config.ClientID}).Verify(s.ctx, rawIDToken)
+    if err != nil {
+        slog.Warn("sso id token rejected", "company_id", config.CompanyID, "error", err)
+        return nil, fmt.Errorf("%w: invalid id_token", ErrSSOFailed)
+    }
+    if !hmac.Equal([]byte(idToken.Nonce), []byte(pending.Nonce)) {
+        return nil, fmt.Errorf("%w: nonce mismatch", ErrSSOFailed)
+    }
+    var claims ssoClaims
+    if err := idToken.Claims(&claims); err != nil {
+        return nil, err
+    }
+    if claims.Email == "" || !claims.EmailVerified {
+        return nil, fmt.Errorf("%w: identity provider did not return a verified email", ErrSSOFailed)
+    }
+    allowed := false
+    domain := emailDomain(claims.Email)
+    for _, d := range strings.Split(config.AllowedEmailDomains, ",") {
+        if d == domain {
+            allowed = true
+            break
+        }
+    }
+    if !allowed {
+        return nil, fmt.Errorf("%w: email domain %q is not allowed for this company", ErrSSOFailed, domain)
+    }
+
+    user, err := s.ssoUser(config, idToken.Issuer, idToken.Subject, claims)
+    if err != nil {
+        return nil, err
+    }
+    if !user.IsActive {
+        return nil, fmt.Errorf("%w: account is disabled", ErrSSODenied)
+    }
+    token, err := s.issueSessionToken(user)
+    if err != nil {
+        return nil, err
+    }
+    return &model.LoginResponse{Token: token, User: *user}, nil
+}
+
+// ssoUser finds the user linked to an external identity. Failing that it
+// links an existing CSR rep of the same company with the same email, or
+// provisions a new user and CSR rep profile.
+func (s *Service) ssoUser(config *model.CompanySSOConfig, issuer, subject string, claims ssoClaims) (*model.User, error) {
+    if identity, err := s.repo.GetUserIdentity(issuer, subject); err == nil {
+        rep, err := s.repo.GetCSRRepByUserID(identity.UserID)
+        if err != nil || rep.CompanyID != config.CompanyID {
+            return nil, fmt.Errorf("%w: identity is linked to another company", ErrSSODenied)
+        }
+        return &identity.User, nil
+    }
+
+    identity := &model.UserIdentity{Issuer: issuer, Subject: subject}
+    if existing, err := s.repo.GetUserByEmail(claims.Email); err == nil {
+        rep, err := s.repo.GetCSRRepByUserID(existing.ID)
+        if existing.Role != model.RoleCSRRep || err != nil || rep.CompanyID != config.CompanyID {
+            return nil, fmt.Errorf("%w: an account with this email already exists", ErrSSOConflict)
+        }
+        identity.UserID = existing.ID
+        if err := s.repo.CreateUserIdentity(identity); err != nil {
+            return nil, err
+        }
+        if existing.EmailVerifiedAt == nil {
+            if err := s.repo.MarkEmailVerified(existing.ID, time.Now()); err != nil {
+                return nil, err
+            }
+        }
+        return existing, nil
+    }
+
+    // SSO users never sign in with a password, so store an unusable one.
+    password, err := randomString(32)
+    if err != nil {
+        return nil, err
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return nil, err
+    }
+    username, err := s.ssoUsername(claims.Email)
+    if err != nil {
+        return nil, err
+    }
+    now := time.Now()
+    user := &model.User{
+        Username:        username,
+        Email:           strings.ToLower(claims.Email),
+        Password:        string(hash),
+        Role:            model.RoleCSRRep,
+        IsActive:        true,
+        EmailVerifiedAt: &now,
+    }
+    firstName, lastName := claims.GivenName, claims.FamilyName
+    if firstName == "" && lastName == "" {
+        if parts := strings.Fields(claims.Name); len(parts) > 0 {
+            firstName, lastName = parts[0], strings.Join(parts[1:], " ")
+        }
+    }
+    if firstName == "" {
+        firstName = username
+    }
+    rep := &model.CSRRep{CompanyID: config.CompanyID, FirstName: firstName, LastName: lastName}
+    if err := s.repo.ProvisionSSOUser(user, rep, identity); err != nil {
+        switch {
+        case errors.Is(err, repository.ErrCompanyNotVerified):
+            return nil, fmt.Errorf("%w: %v", ErrSSODenied, err)
+        case errors.Is(err, gorm.ErrDuplicatedKey):
+            return nil, fmt.Errorf("%w: the account was created by another sign-in, try again", ErrSSOConflict)
+        }
+        return nil, err
+    }
+    slog.Info("provisioned sso user", "user_id", user.ID, "company_id", config.CompanyID)
+    return user, nil
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// ssoUsername derives a free username from the local part of email.
+func (s *Service) ssoUsername(email string) (string, error) {
+    base := usernameUnsafe.ReplaceAllString(strings.ToLower(strings.SplitN(email, "@", 2)[0]), "")
+    if base == "" {
+        base = "user"
+    }
+    if _, err := s.repo.GetUserByUsername(base); err != nil {
+        return base, nil
+    }
+    suffix := make([]byte, 3)
+    if _, err := rand.Read(suffix); err != nil {
+        return "", err
+    }
+    return base + "-" + hex.EncodeToString(suffix), nil
+}
+
+// API keys
+
+const (
+    // APIKeyPrefix starts every API key, so AuthMiddleware can tell them
+    // apart from JWTs and secret scanners can recognise leaked keys.
+    APIKeyPrefix        = "csrk_"
+    apiKeyTouchInterval = time.Minute
+)
+
+// APIKeyScopes lists the route groups an API key can be scoped to. A scope
+// grants full access to its group; "<scope>:read" grants read-only access.
+var APIKeyScopes = []string{"profile", "pin", "csr", "matches", "company", "admin", "platform"}
+
+var ErrInvalidAPIKey = errors.New("invalid, expired or revoked API key")
+
+func IsAPIKey(token string) bool {
+    return strings.HasPrefix(token, APIKeyPrefix)
+}
+
+func normalizeAPIKeyScopes(scopes []string) ([]string, error) {
+    seen := map[string]bool{}
+    var out []string
+    for _, scope := range scopes {
+        scope = strings.ToLower(strings.TrimSpace(scope))
+        group := strings.TrimSuffix(scope, ":read")
+        known := false
+        for _, s := range APIKeyScopes {
+            if s == group {
+                known = true
+                break
+            }
+        }
+        if !known {
+            return nil, fmt.Errorf("unknown scope %q", scope)
+        }
+        if !seen[scope] {
+            seen[scope] = true
+            out = append(out, scope)
+        }
+    }
+    return out, nil
+}
+
+// CreateAPIKey issues a key that authenticates as the given user. The
+// plaintext key is only returned here.
+func (s *Service) CreateAPIKey(admin *model.User, req model.CreateAPIKeyRequest, ipAddress string) (*model.CreatedAPIKey, error) {
+    owner, err := s.repo.GetUserByID(req.UserID)
+    if err != nil || !owner.IsActive {
+        return nil, fmt.Errorf("user not found")
+    }
+    scopes, err := normalizeAPIKeyScopes(req.Scopes)
+    if err != nil {
+        return nil, err
+    }
+    if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+        return nil, fmt.Errorf("expires_at must be in the future")
+    }
+    prefix := make([]byte, 4)
+    if _, err := rand.Read(prefix); err != nil {
+        return nil, err
+    }
+    secret, err := randomString(32)
+    if err != nil {
+        return nil, err
+    }
+    key := &model.APIKey{
+        UserID:      owner.ID,
+        CreatedByID: admin.ID,
+        Name:        req.Name,
+        Prefix:      hex.EncodeToString(prefix),
+        Scopes:      strings.Join(scopes, ","),
+        ExpiresAt:   req.ExpiresAt,
+    }
+    plaintext := APIKeyPrefix + key.Prefix + "_" + secret
+    key.KeyHash = hashToken(plaintext)
+    if err := s.repo.CreateAPIKey(key); err != nil {
+        return nil, err
+    }
+    key.User = *owner
+    s.RecordAudit(&admin.ID, "api_key.created", "api_key", &key.ID, map[string]interface{}{
+        "user_id": owner.ID, "prefix": key.Prefix, "scopes": scopes,
+    }, ipAddress)
+    return &model.CreatedAPIKey{APIKey: *key, Key: plaintext}, nil
+}
+
+func (s *Service) ListAPIKeys(userID *uint, page, pageSize int) (*model.PaginatedResponse, error) {
+    keys, total, err := s.repo.ListAPIKeys(userID, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: keys,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+func (s *Service) RevokeAPIKey(admin *model.User, keyID uint, ipAddress string) error {
+    key, err := s.repo.GetAPIKeyByID(keyID)
+    if err != nil {
+        return fmt.Errorf("API key not found")
+    }
+    if err := s.repo.RevokeAPIKey(key.ID, time.Now()); err != nil {
+        return err
+    }
+    s.RecordAudit(&admin.ID, "api_key.revoked", "api_key", &key.ID, map[string]interface{}{"prefix": key.Prefix}, ipAddress)
+    return nil
+}
+
+// AuthenticateAPIKey returns the user a key acts for and the key's scopes.
+func (s *Service) AuthenticateAPIKey(plaintext, ipAddress string) (*model.User, []string, error) {
+    parts := strings.SplitN(strings.TrimPrefix(plaintext, APIKeyPrefix), "_", 2)
+    if len(parts) != 2 {
+        return nil, nil, ErrInvalidAPIKey
+    }
+    key, err := s.repo.GetAPIKeyByPrefix(parts[0])
+    if err != nil || !hmac.Equal([]byte(key.KeyHash), []byte(hashToken(plaintext))) {
+        return nil, nil, ErrInvalidAPIKey
+    }
+    now := time.Now()
+    if key.RevokedAt != nil || (key.ExpiresAt != nil && now.After(*key.ExpiresAt)) || !key.User.IsActive {
+        return nil, nil, ErrInvalidAPIKey
+    }
+    if err := s.repo.TouchAPIKey(key.ID, ipAddress, now, apiKeyTouchInterval); err != nil {
+        slog.Warn("failed to record API key use", "prefix", key.Prefix, "error", err)
+    }
+    return &key.User, strings.Split(key.Scopes, ","), nil
+}
+
+// Authorization
+
+var ErrForbidden = errors.New("insufficient permissions")
+
+// Policy returns the permission policy the service enforces.
+func (s *Service) Policy() *policy.Policy {
+    return s.policy
+}
+
+// policySubject describes user for policy decisions, including the company
+// of users attached to one.
+func (s *Service) policySubject(user *model.User) policy.Subject {
+    subject := policy.Subject{UserID: user.ID, Role: user.Role}
+    switch user.Role {
+    case model.RoleCSRRep:
+        if rep, err := s.repo.GetCSRRepByUserID(user.ID); err == nil {
+            subject.CompanyID = rep.CompanyID
+        }
+    case model.RoleCompanyAdmin:
+        if companyAdmin, err := s.repo.GetCompanyAdminByUserID(user.ID); err == nil {
+            subject.CompanyID = companyAdmin.CompanyID
+        }
+    }
+    return subject
+}
+
+// MatchResource describes match for policy decisions: its PIN and its CSR
+// rep own it, each in their own role, and it belongs to the rep's company.
+func MatchResource(match *model.Match) policy.Resource {
+    return policy.Resource{
+        Owners: []policy.Owner{
+            {UserID: match.PIN.UserID, Role: model.RolePIN},
+            {UserID: match.CSRRep.UserID, Role: model.RoleCSRRep},
+        },
+        CompanyID: match.CSRRep.CompanyID,
+    }
+}
+
+// RequestResource describes request for policy decisions: its PIN owns it.
+func RequestResource(request *model.PINRequest) policy.Resource {
+    return policy.Resource{Owners: []policy.Owner{{UserID: request.PIN.UserID, Role: model.RolePIN}}}
+}
+
+// Authorize checks that user may perform action on resource.
+func (s *Service) Authorize(user *model.User, action string, resource policy.Resource) error {
+    if !s.policy.Can(s.policySubject(user), action, resource) {
+        return ErrForbidden
+    }
+    return nil
+}
+
+// LoadMatch loads match id for the authorization middleware, which hands
+// it on to the handler.
+func (s *Service) LoadMatch(id uint) (*model.Match, policy.Resource, error) {
+    match, err := s.repo.GetMatchByID(id)
+    if err != nil {
+        return nil, policy.Resource{}, fmt.Errorf("match not found")
+    }
+    return match, MatchResource(match), nil
+}
+
+// LoadRequest loads PIN request id for the authorization middleware, which
+// hands it on to the handler.
+func (s *Service) LoadRequest(id uint) (*model.PINRequest, policy.Resource, error) {
+    request, err := s.repo.GetPINRequestByID(id)
+    if err != nil {
+        return nil, policy.Resource{}, fmt.Errorf("request not found")
+    }
+    return request, RequestResource(request), nil
+}
+
+// Invitations
+
+// ErrAccountExists is returned when an invitation names an email that
+// already belongs to another account in the tenant.
+var ErrAccountExists = errors.New("an account with this email already exists")
+
+// invitationPending reports whether user is an invitee of role who has not
+// accepted the invitation yet; inviting them again resends the link.
+func invitationPending(user *model.User, role model.UserRole) bool {
+    return user.Role == role && user.EmailVerifiedAt == nil
+}
+
+// newInvitedUser prepares an account for someone invited by email. It has
+// an unusable password until the invitee follows the emailed link. The
+// caller checks first that no account uses the email.
+func (s *Service) newInvitedUser(email string, role model.UserRole) (*model.User, error) {
+    username, err := s.ssoUsername(email)
+    if err != nil {
+        return nil, err
+    }
+    password, err := randomString(32)
+    if err != nil {
+        return nil, err
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+    if err != nil {
+        return nil, err
+    }
+    return &model.User{Username: username, Email: email, Password: string(hash), Role: role, IsActive: true}, nil
+}
+
+// sendInvitation emails a password-setting link, replacing any link sent
+// earlier.
+func (s *Service) sendInvitation(user *model.User, company *model.Company) error {
+    if err := s.repo.InvalidateAuthTokens(user.ID, model.TokenPurposeInvitation, time.Now()); err != nil {
+        return err
+    }
+    token, err := s.issueAuthToken(user.ID, model.TokenPurposeInvitation, s.cfg.InvitationTTL, "")
+    if err != nil {
+        return err
+    }
+    return s.mailer.Send(s.ctx, mailer.Message{
+        To:      user.Email,
+        Subject: fmt.Sprintf("You have been invited to volunteer with %s", company.Name),
+        Body: fmt.Sprintf("Hello,\n\n%s has invited you to the CSR volunteer platform. Your username is %s.\nChoose a password using the link below. It expires in %s.\n\n%s/accept-invitation?token=%s\n",
+            company.Name, user.Username, s.cfg.InvitationTTL, s.cfg.AppBaseURL, token),
+    })
+}
+
+// InviteCompanyAdmin creates a company_admin account for companyID. Inviting
+// someone whose invitation to the company is still pending sends them a new
+// link, so a failed email can be retried.
+func (s *Service) InviteCompanyAdmin(admin *model.User, companyID uint, req model.InviteUserRequest, ipAddress string) (*model.CompanyAdmin, error) {
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    email := strings.ToLower(strings.TrimSpace(req.Email))
+    if existing, err := s.repo.GetUserByEmail(email); err == nil {
+        companyAdmin, err := s.repo.GetCompanyAdminByUserID(existing.ID)
+        if err != nil || !invitationPending(existing, model.RoleCompanyAdmin) || companyAdmin.CompanyID != company.ID {
+            return nil, ErrAccountExists
+        }
+        if err := s.sendInvitation(existing, company); err != nil {
+            return nil, err
+        }
+        s.RecordAudit(&admin.ID, "company_admin.reinvited", "user", &existing.ID, map[string]interface{}{"company_id": company.ID}, ipAddress)
+        companyAdmin.User = *existing
+        companyAdmin.Company = *company
+        return companyAdmin, nil
+    }
+    user, err := s.newInvitedUser(email, model.RoleCompanyAdmin)
+    if err != nil {
+        return nil, err
+    }
+    companyAdmin := &model.CompanyAdmin{CompanyID: company.ID, FirstName: req.FirstName, LastName: req.LastName}
+    if err := s.repo.CreateUserWithCompanyAdmin(user, companyAdmin); err != nil {
+        if errors.Is(err, gorm.ErrDuplicatedKey) {
+            return nil, ErrAccountExists
+        }
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "company_admin.invited", "user", &user.ID, map[string]interface{}{"company_id": company.ID}, ipAddress)
+    if err := s.sendInvitation(user, company); err != nil {
+        return nil, err
+    }
+    companyAdmin.User = *user
+    companyAdmin.Company = *company
+    return companyAdmin, nil
+}
+
+// Company administration
+
+// managedCompanyID returns the company a company admin manages.
+func (s *Service) managedCompanyID(user *model.User) (uint, error) {
+    companyAdmin, err := s.repo.GetCompanyAdminByUserID(user.ID)
+    if err != nil {
+        return 0, fmt.Errorf("company admin profile not found")
+    }
+    return companyAdmin.CompanyID, nil
+}
+
+func (s *Service) GetCompanyReps(user *model.User, page, pageSize int) (*model.PaginatedResponse, error) {
+    companyID, err := s.managedCompanyID(user)
+    if err != nil {
+        return nil, err
+    }
+    reps, total, err := s.repo.GetCSRRepsByCompanyID(companyID, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: reps,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// InviteCSRRep creates a CSR rep account in the company admin's company.
+// Inviting a rep whose invitation is still pending sends them a new link.
+func (s *Service) InviteCSRRep(user *model.User, req model.InviteUserRequest, ipAddress string) (*model.CSRRep, error) {
+    companyID, err := s.managedCompanyID(user)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.Authorize(user, policy.RepManage, policy.Resource{CompanyID: companyID}); err != nil {
+        return nil, err
+    }
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    email := strings.ToLower(strings.TrimSpace(req.Email))
+    if existing, err := s.repo.GetUserByEmail(email); err == nil {
+        rep, err := s.repo.GetCSRRepByUserID(existing.ID)
+        if err != nil || !invitationPending(existing, model.RoleCSRRep) || rep.CompanyID != companyID {
+            return nil, ErrAccountExists
+        }
+        if err := s.sendInvitation(existing, company); err != nil {
+            return nil, err
+        }
+        s.RecordAudit(&user.ID, "csr_rep.reinvited", "user", &existing.ID, map[string]interface{}{"company_id": companyID}, ipAddress)
+        rep.User = *existing
+        return rep, nil
+    }
+    invitee, err := s.newInvitedUser(email, model.RoleCSRRep)
+    if err != nil {
+        return nil, err
+    }
+    rep := &model.CSRRep{
+        CompanyID:  companyID,
+        FirstName:  req.FirstName,
+        LastName:   req.LastName,
+        Phone:      req.Phone,
+        Department: req.Department,
+        Position:   req.Position,
+    }
+    if err := s.repo.CreateUserWithCSRRep(invitee, rep); err != nil {
+        if errors.Is(err, gorm.ErrDuplicatedKey) {
+            return nil, ErrAccountExists
+        }
+        return nil, err
+    }
+    s.RecordAudit(&user.ID, "csr_rep.invited", "user", &invitee.ID, map[string]interface{}{"company_id": companyID}, ipAddress)
+    if err := s.sendInvitation(invitee, company); err != nil {
+        return nil, err
+    }
+    rep.User = *invitee
+    return rep, nil
+}
+
+// SetCSRRepActive deactivates or reactivates a rep's account.
+func (s *Service) SetCSRRepActive(user *model.User, repID uint, active bool, ipAddress string) (*model.CSRRep, error) {
+    rep, err := s.repo.GetCSRRepByID(repID)
+    if err != nil {
+        return nil, fmt.Errorf("CSR rep not found")
+    }
+    if err := s.Authorize(user, policy.RepManage, policy.Resource{CompanyID: rep.CompanyID}); err != nil {
+        return nil, err
+    }
+    if err := s.repo.SetUserActive(rep.UserID, active); err != nil {
+        return nil, err
+    }
+    if !active {
+        if err := s.repo.RevokeSessions(rep.UserID, time.Now()); err != nil {
+            return nil, err
+        }
+    }
+    action := "csr_rep.deactivated"
+    if active {
+        action = "csr_rep.reactivated"
+    }
+    s.RecordAudit(&user.ID, action, "user", &rep.UserID, map[string]interface{}{"company_id": rep.CompanyID}, ipAddress)
+    rep.User.IsActive = active
+    return rep, nil
+}
+
+func (s *Service) GetCompanyShortlists(user *model.User, page, pageSize int) (*model.PaginatedResponse, error) {
+    companyID, err := s.managedCompanyID(user)
+    if err != nil {
+        return nil, err
+    }
+    shortlists, total, err := s.repo.GetShortlistsByCompanyID(companyID, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: shortlists,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+func (s *Service) GetCompanyMatches(user *model.User, filter model.MatchFilter, page, pageSize int) (*model.PaginatedResponse, error) {
+    companyID, err := s.managedCompanyID(user)
+    if err != nil {
+        return nil, err
+    }
+    filter.CompanyID = &companyID
+    matches, total, err := s.repo.SearchMatches(filter, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data: matches,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// ReassignMatch hands a match over to another active rep of the same company.
+func (s *Service) ReassignMatch(user *model.User, match *model.Match, csrRepID uint, ipAddress string) (*model.Match, error) {
+    if err := s.authorizeMatch(user, policy.MatchReassign, match); err != nil {
+        return nil, err
+    }
+    if match.Status == "completed" || match.Status == "cancelled" {
+        return nil, fmt.Errorf("cannot reassign a %s match", match.Status)
+    }
+    target, err := s.repo.GetCSRRepByID(csrRepID)
+    if err != nil {
+        return nil, fmt.Errorf("CSR rep not found")
+    }
+    if target.CompanyID != match.CSRRep.CompanyID || !target.User.IsActive {
+        return nil, fmt.Errorf("matches can only be reassigned to an active rep of the same company")
+    }
+    if err := s.repo.ReassignMatch(match.ID, target.ID); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&user.ID, "match.reassigned", "match", &match.ID, map[string]interface{}{
+        "from_csr_rep_id": match.CSRRepID, "to_csr_rep_id": target.ID,
+    }, ipAddress)
+    return s.repo.GetMatchByID(match.ID)
+}
+
+// GetCompanyDashboard summarises the company admin's company: reps,
+// shortlists, matches by status, volunteer hours per rep and impact.
+func (s *Service) GetCompanyDashboard(user *model.User, filter model.HoursFilter) (dashboard *model.CompanyDashboard, err error) {
+    s, span := s.startSpan("Service.GetCompanyDashboard")
+    defer func() { tracing.End(span, err) }()
+    companyID, err := s.managedCompanyID(user)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.Authorize(user, policy.DashboardRead, policy.Resource{CompanyID: companyID}); err != nil {
+        return nil, err
+    }
+    dashboard, err = s.repo.GetCompanyActivityCounts(companyID)
+    if err != nil {
+        return nil, err
+    }
+    start, end := dashboardWindow(filter, time.Now())
+    filter = model.HoursFilter{CompanyID: &companyID, StartDate: &start, EndDate: &end}
+    if dashboard.VolunteerHours, err = s.repo.GetHoursByCSRRep(filter); err != nil {
+        return nil, err
+    }
+    if dashboard.Impact, err = s.repo.GetCompanyImpactMetrics(companyID, start, end); err != nil {
+        return nil, err
+    }
+    return dashboard, nil
+}
+
+// dashboardWindow returns the period the dashboard's hours and impact panels
+// both cover: the filter's dates, defaulting to the year to date.
+func dashboardWindow(filter model.HoursFilter, now time.Time) (time.Time, time.Time) {
+    start := time.Date(now.Year(), 1, 1, 0, 0, 0, 0, now.Location())
+    end := now
+    if filter.StartDate != nil {
+        start = *filter.StartDate
+    }
+    if filter.EndDate != nil {
+        end = *filter.EndDate
+    }
+    return start, end
+}
+
+// User administration
+
+func (s *Service) SearchUsers(filter model.UserFilter, page, pageSize int) (*model.PaginatedResponse, error) {
+    users, total, err := s.repo.SearchUsers(filter, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data:       users,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+// GetUserDetail returns a user with their PIN, CSR rep and company admin
+// profiles, where present.
+func (s *Service) GetUserDetail(userID uint) (*model.UserDetail, error) {
+    user, err := s.repo.GetUserByID(userID)
+    if err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    detail := &model.UserDetail{User: *user}
+    if pin, err := s.repo.GetPINByUserID(userID); err == nil {
+        detail.PIN = pin
+    }
+    if rep, err := s.repo.GetCSRRepByUserID(userID); err == nil {
+        detail.CSRRep = rep
+    }
+    if companyAdmin, err := s.repo.GetCompanyAdminByUserID(userID); err == nil {
+        detail.CompanyAdmin = companyAdmin
+    }
+    return detail, nil
+}
+
+// managedUser loads a user an admin is about to change. Admins cannot
+// change their own account this way, and only super-admins can change
+// super-admins.
+func (s *Service) managedUser(admin *model.User, userID uint) (*model.User, error) {
+    if admin.ID == userID {
+        return nil, fmt.Errorf("you cannot change your own account here")
+    }
+    user, err := s.repo.GetUserByID(userID)
+    if err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    if user.Role == model.RoleSuperAdmin && admin.Role != model.RoleSuperAdmin {
+        return nil, ErrForbidden
+    }
+    return user, nil
+}
+
+// SetUserActive deactivates or reactivates an account. Deactivation also
+// revokes the user's sessions.
+func (s *Service) SetUserActive(admin *model.User, userID uint, active bool, ipAddress string) (*model.User, error) {
+    user, err := s.managedUser(admin, userID)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.repo.SetUserActive(userID, active); err != nil {
+        return nil, err
+    }
+    action := "user.reactivated"
+    if !active {
+        if err := s.repo.RevokeSessions(userID, time.Now()); err != nil {
+            return nil, err
+        }
+        action = "user.deactivated"
+    }
+    s.RecordAudit(&admin.ID, action, "user", &userID, map[string]interface{}{"username": user.Username}, ipAddress)
+    return s.repo.GetUserByID(userID)
+}
+
+// ForcePasswordReset replaces the user's password with an unusable one,
+// revokes their sessions and emails them a reset link.
+func (s *Service) ForcePasswordReset(admin *model.User, userID uint, ipAddress string) error {
+    user, err := s.managedUser(admin, userID)
+    if err != nil {
+        return err
+    }
+    unusable := make([]byte, 32)
+    if _, err := rand.Read(unusable); err != nil {
+        return err
+    }
+    hash, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(unusable)), bcrypt.DefaultCost)
+    if err != nil {
+        return err
+    }
+    if err := s.repo.SetUserPassword(userID, string(hash)); err != nil {
+        return err
+    }
+    now := time.Now()
+    if err := s.repo.RevokeSessions(userID, now); err != nil {
+        return err
+    }
+    if err := s.repo.InvalidateAuthTokens(userID, model.TokenPurposePasswordReset, now); err != nil {
+        return err
+    }
+    s.RecordAudit(&admin.ID, "user.password_reset_forced", "user", &userID, map[string]interface{}{"username": user.Username}, ipAddress)
+    return s.sendPasswordReset(user)
+}
+
+// SetUserRole changes a user's role and revokes their sessions and API
+// keys, which were granted under the old role. Only super-admins can grant
+// the super_admin role. Profiles belonging to the old role are kept, and
+// the profile the new role needs is created unless the user has one.
+func (s *Service) SetUserRole(admin *model.User, userID uint, req model.SetRoleRequest, ipAddress string) (*model.User, error) {
+    user, err := s.managedUser(admin, userID)
+    if err != nil {
+        return nil, err
+    }
+    if req.Role == model.RoleSuperAdmin && admin.Role != model.RoleSuperAdmin {
+        return nil, ErrForbidden
+    }
+    if user.Role == req.Role {
+        return user, nil
+    }
+    profile, err := s.roleProfile(user, req)
+    if err != nil {
+        return nil, err
+    }
+    if err := s.repo.ChangeUserRole(userID, req.Role, profile, time.Now()); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "user.role_changed", "user", &userID, map[string]interface{}{"from": user.Role, "to": req.Role}, ipAddress)
+    return s.repo.GetUserByID(userID)
+}
+
+// roleProfile returns the profile user needs to take on the requested role,
+// or nil if the role needs none or the user kept one from an earlier role.
+// A kept rep or company admin profile must belong to the requested company.
+func (s *Service) roleProfile(user *model.User, req model.SetRoleRequest) (interface{}, error) {
+    switch req.Role {
+    case model.RolePIN:
+        if _, err := s.repo.GetPINByUserID(user.ID); err == nil {
+            return nil, nil
+        }
+        if req.FirstName == "" {
+            return nil, fmt.Errorf("first_name is required to create the PIN profile")
+        }
+        return &model.PIN{UserID: user.ID, FirstName: req.FirstName, LastName: req.LastName}, nil
+    case model.RoleCSRRep:
+        if rep, err := s.repo.GetCSRRepByUserID(user.ID); err == nil {
+            if req.CompanyID != 0 && req.CompanyID != rep.CompanyID {
+                return nil, fmt.Errorf("the user is a CSR rep of another company")
+            }
+            return nil, nil
+        }
+        if req.CompanyID == 0 || req.FirstName == "" {
+            return nil, fmt.Errorf("company_id and first_name are required to create the CSR rep profile")
+        }
+        return &model.CSRRep{UserID: user.ID, CompanyID: req.CompanyID, FirstName: req.FirstName, LastName: req.LastName}, nil
+    case model.RoleCompanyAdmin:
+        if companyAdmin, err := s.repo.GetCompanyAdminByUserID(user.ID); err == nil {
+            if req.CompanyID != 0 && req.CompanyID != companyAdmin.CompanyID {
+                return nil, fmt.Errorf("the user administers another company")
+            }
+            return nil, nil
+        }
+        if req.CompanyID == 0 || req.FirstName == "" {
+            return nil, fmt.Errorf("company_id and first_name are required to create the company admin profile")
+        }
+        if _, err := s.repo.GetCompanyByID(req.CompanyID); err != nil {
+            return nil, fmt.Errorf("company not found")
+        }
+        return &model.CompanyAdmin{UserID: user.ID, CompanyID: req.CompanyID, FirstName: req.FirstName, LastName: req.LastName}, nil
+    }
+    return nil, nil
+}
+
+// Company management
+
+func (s *Service) GetCompany(companyID uint) (*model.Company, error) {
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    return company, nil
+}
+
+func (s *Service) UpdateCompany(admin *model.User, companyID uint, req model.UpdateCompanyRequest, ipAddress string) (*model.Company, error) {
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    company.Name = req.Name
+    company.Industry = req.Industry
+    company.Address = req.Address
+    company.Phone = req.Phone
+    company.Email = req.Email
+    company.Website = req.Website
+    company.Description = req.Description
+    if err := s.repo.UpdateCompany(company); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "company.updated", "company", &company.ID, nil, ipAddress)
+    return company, nil
+}
+
+// ReviewCompany sets a company's verification status with the reviewer's
+// notes. Suspending a company stops new CSR reps from joining it; existing
+// reps keep their accounts.
+func (s *Service) ReviewCompany(admin *model.User, companyID uint, req model.CompanyVerificationRequest, ipAddress string) (*model.Company, error) {
+    company, err := s.repo.GetCompanyByID(companyID)
+    if err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    if err := s.repo.SetCompanyVerification(companyID, req.Status, req.Notes, admin.ID, time.Now()); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "company.reviewed", "company", &companyID, map[string]interface{}{
+        "from": company.VerificationStatus, "to": req.Status, "notes": req.Notes,
+    }, ipAddress)
+    return s.repo.GetCompanyByID(companyID)
+}
+
+// SetCompanyArchived archives or restores a company. An archived company
+// stays listed, but no new CSR reps can join it.
+func (s *Service) SetCompanyArchived(admin *model.User, companyID uint, archived bool, ipAddress string) (*model.Company, error) {
+    if _, err := s.repo.GetCompanyByID(companyID); err != nil {
+        return nil, fmt.Errorf("company not found")
+    }
+    var at *time.Time
+    action := "company.restored"
+    if archived {
+        now := time.Now()
+        at = &now
+        action = "company.archived"
+    }
+    if err := s.repo.SetCompanyArchived(companyID, at); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, action, "company", &companyID, nil, ipAddress)
+    return s.repo.GetCompanyByID(companyID)
+}
+
+// Service categories
+
+var categoryLocalePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// baseLocale returns the language part of a locale, e.g. "pt" for "pt-br".
+func baseLocale(locale string) string {
+    base, _, _ := strings.Cut(locale, "-")
+    return base
+}
+
+// localizeCategory picks the first of the preferred locales the category is
+// available in. Each locale is tried as is, then by its language, where the
+// default locale's language counts as available; regional translations such
+// as en-gb are thus served even if the default is en. Failing all, the
+// default locale is used.
+func (s *Service) localizeCategory(category model.ServiceCategory, locales []string) model.LocalizedCategory {
+    localized := model.LocalizedCategory{
+        ID: category.ID, ParentID: category.ParentID, Locale: s.cfg.DefaultLocale,
+        Name: category.Name, Description: category.Description,
+        Icon: category.Icon, Color: category.Color, SortOrder: category.SortOrder,
+        Children: []model.LocalizedCategory{},
+    }
+    for _, locale := range locales {
+        var match *model.ServiceCategoryTranslation
+        for i, t := range category.Translations {
+            if t.Locale == locale {
+                match = &category.Translations[i]
+                break
+            }
+        }
+        if match == nil {
+            language := baseLocale(locale)
+            if locale == "*" || language == baseLocale(s.cfg.DefaultLocale) {
+                return localized
+            }
+            for i, t := range category.Translations {
+                if baseLocale(t.Locale) == language && (match == nil || t.Locale == language) {
+                    match = &category.Translations[i]
+                }
+            }
+        }
+        if match != nil {
+            localized.Locale = match.Locale
+            localized.Name = match.Name
+            if match.Description != "" {
+                localized.Description = match.Description
+            }
+            return localized
+        }
+    }
+    return localized
+}
+
+// GetCategoryTree returns the active categories as a tree in the preferred
+// locales, ordered by sort order and name. Subcategories of an inactive
+// category are left out with it.
+func (s *Service) GetCategoryTree(locales []string) ([]model.LocalizedCategory, error) {
+    categories, err := s.repo.GetAllServiceCategories()
+    if err != nil {
+        return nil, err
+    }
+    children := map[uint][]model.ServiceCategory{}
+    var roots []model.ServiceCategory
+    for _, category := range categories {
+        if category.ParentID == nil {
+            roots = append(roots, category)
+        } else {
+            children[*category.ParentID] = append(children[*category.ParentID], category)
+        }
+    }
+    var build func([]model.ServiceCategory) []model.LocalizedCategory
+    build = func(level []model.ServiceCategory) []model.LocalizedCategory {
+        nodes := make([]model.LocalizedCategory, 0, len(level))
+        for _, category := range level {
+            node := s.localizeCategory(category, locales)
+            node.Children = build(children[category.ID])
+            nodes = append(nodes, node)
+        }
+        return nodes
+    }
+    return build(roots), nil
+}
+
+// SaveCategoryTranslation sets a category's name and description in locale.
+func (s *Service) SaveCategoryTranslation(admin *model.User, categoryID uint, locale string, req model.CategoryTranslationRequest, ipAddress string) (*model.ServiceCategory, error) {
+    locale = strings.ToLower(locale)
+    if !categoryLocalePattern.MatchString(locale) {
+        return nil, fmt.Errorf("invalid locale %q", locale)
+    }
+    if _, err := s.repo.GetServiceCategoryByID(categoryID); err != nil {
+        return nil, fmt.Errorf("category not found")
+    }
+    translation := &model.ServiceCategoryTranslation{CategoryID: categoryID, Locale: locale, Name: req.Name, Description: req.Description}
+    if err := s.repo.SaveCategoryTranslation(translation); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "category.translation_saved", "service_category", &categoryID, map[string]interface{}{"locale": locale}, ipAddress)
+    return s.repo.GetServiceCategoryByID(categoryID)
+}
+
+func (s *Service) DeleteCategoryTranslation(admin *model.User, categoryID uint, locale string, ipAddress string) error {
+    locale = strings.ToLower(locale)
+    if err := s.repo.DeleteCategoryTranslation(categoryID, locale); err != nil {
+        return fmt.Errorf("translation not found")
+    }
+    s.RecordAudit(&admin.ID, "category.translation_deleted", "service_category", &categoryID, map[string]interface{}{"locale": locale}, ipAddress)
+    return nil
+}
+
+func (s *Service) GetArchivedCategories() ([]model.ServiceCategory, error) {
+    return s.repo.GetArchivedServiceCategories()
+}
+
+// SetCategoryArchived archives or restores a category together with its
+// subcategories. Archived categories take no new requests; existing requests
+// keep them. A subcategory cannot be restored while its parent is archived.
+func (s *Service) SetCategoryArchived(admin *model.User, categoryID uint, archived bool, ipAddress string) (*model.ServiceCategory, error) {
+    category, err := s.repo.GetServiceCategoryByID(categoryID)
+    if err != nil {
+        return nil, fmt.Errorf("category not found")
+    }
+    if category.MergedIntoID != nil {
+        return nil, fmt.Errorf("category has been merged into category %d", *category.MergedIntoID)
+    }
+    var at *time.Time
+    action := "category.restored"
+    if archived {
+        now := time.Now()
+        at = &now
+        action = "category.archived"
+    } else if category.ParentID != nil {
+        if parent, err := s.repo.GetServiceCategoryByID(*category.ParentID); err == nil && parent.ArchivedAt != nil {
+            return nil, fmt.Errorf("restore the parent category first")
+        }
+    }
+    affected, err := s.repo.SetCategoryArchived(categoryID, at)
+    if err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, action, "service_category", &categoryID, map[string]interface{}{"categories": affected}, ipAddress)
+    return s.repo.GetServiceCategoryByID(categoryID)
+}
+
+// MergeCategories consolidates a duplicate category into the target: its
+// requests and subcategories move to the target and it is archived.
+func (s *Service) MergeCategories(admin *model.User, sourceID uint, req model.MergeCategoryRequest, ipAddress string) (*model.CategoryMergeResult, error) {
+    if sourceID == req.TargetID {
+        return nil, fmt.Errorf("cannot merge a category into itself")
+    }
+    requests, subcategories, err := s.repo.MergeServiceCategories(sourceID, req.TargetID, time.Now())
+    if err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "category.merged", "service_category", &sourceID, map[string]interface{}{
+        "target_id": req.TargetID, "requests_moved": requests, "subcategories_moved": subcategories,
+    }, ipAddress)
+    source, err := s.repo.GetServiceCategoryByID(sourceID)
+    if err != nil {
+        return nil, err
+    }
+    target, err := s.repo.GetServiceCategoryByID(req.TargetID)
+    if err != nil {
+        return nil, err
+    }
+    return &model.CategoryMergeResult{Source: *source, Target: *target, RequestsMoved: requests, SubcategoriesMoved: subcategories}, nil
+}
+
+// Abuse reports and blocks
+
+var (
+    ErrAlreadyReported     = errors.New("you have already reported this")
+    ErrAbuseReportNotFound = errors.New("report not found")
+    // ErrInvalidTriage is returned for a status change or block that a
+    // report does not allow.
+    ErrInvalidTriage      = errors.New("invalid triage")
+    ErrAbuseReportChanged = repository.ErrAbuseReportChanged
+)
+
+// abuseReportTransitions lists the statuses a report can move to. Resolved
+// and dismissed reports are final.
+var abuseReportTransitions = map[string][]string{
+    model.AbuseReportOpen:          {model.AbuseReportInvestigating, model.AbuseReportResolved, model.AbuseReportDismissed},
+    model.AbuseReportInvestigating: {model.AbuseReportOpen, model.AbuseReportInvestigating, model.AbuseReportResolved, model.AbuseReportDismissed},
+}
+
+func abuseReportTransitionAllowed(from, to string) bool {
+    for _, status := range abuseReportTransitions[from] {
+        if status == to {
+            return true
+        }
+    }
+    return false
+}
+
+// fileAbuseReport records a report about reportedUserID unless the reporter
+// already has an unhandled one about the same target.
+func (s *Service) fileAbuseReport(reporter *model.User, targetType string, targetID, reportedUserID uint, req model.CreateAbuseReportRequest, ipAddress string) (*model.AbuseReport, error) {
+    if reportedUserID == reporter.ID {
+        return nil, fmt.Errorf("you cannot report yourself")
+    }
+    report := &model.AbuseReport{
+        ReporterID:     reporter.ID,
+        TargetType:     targetType,
+        TargetID:       targetID,
+        ReportedUserID: reportedUserID,
+        Reason:         req.Reason,
+        Details:        strings.TrimSpace(req.Details),
+        Status:         model.AbuseReportOpen,
+    }
+    if err := s.repo.CreateAbuseReport(report); err != nil {
+        if errors.Is(err, gorm.ErrDuplicatedKey) {
+            return nil, fmt.Errorf("%w %s", ErrAlreadyReported, targetType)
+        }
+        return nil, err
+    }
+    s.RecordAudit(&reporter.ID, "abuse_report.created", "abuse_report", &report.ID, map[string]interface{}{
+        "target_type": targetType, "target_id": targetID, "reason": req.Reason,
+    }, ipAddress)
+    return report, nil
+}
+
+// ReportRequest flags a published request, e.g. as fraudulent, against the
+// PIN who posted it.
+func (s *Service) ReportRequest(user *model.User, requestID uint, req model.CreateAbuseReportRequest, ipAddress string) (*model.AbuseReport, error) {
+    request, err := s.repo.GetPINRequestByID(requestID)
+    if err != nil || request.ModerationStatus != model.ModerationApproved {
+        return nil, fmt.Errorf("request not found")
+    }
+    return s.fileAbuseReport(user, model.AbuseTargetRequest, requestID, request.PIN.UserID, req, ipAddress)
+}
+
+// ReportMatch reports the other party of a match: the CSR rep when the PIN
+// reports, the PIN otherwise.
+func (s *Service) ReportMatch(user *model.User, matchID uint, req model.CreateAbuseReportRequest, ipAddress string) (*model.AbuseReport, error) {
+    match, err := s.loadMatchFor(user, policy.MatchRead, matchID)
+    if err != nil {
+        return nil, err
+    }
+    reported := match.PIN.UserID
+    if user.ID == match.PIN.UserID {
+        reported = match.CSRRep.UserID
+    }
+    return s.fileAbuseReport(user, model.AbuseTargetMatch, matchID, reported, req, ipAddress)
+}
+
+func (s *Service) ReportUser(user *model.User, userID uint, req model.CreateAbuseReportRequest, ipAddress string) (*model.AbuseReport, error) {
+    if _, err := s.repo.GetUserByID(userID); err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    return s.fileAbuseReport(user, model.AbuseTargetUser, userID, userID, req, ipAddress)
+}
+
+func (s *Service) SearchAbuseReports(filter model.AbuseReportFilter, page, pageSize int) (*model.PaginatedResponse, error) {
+    reports, total, err := s.repo.SearchAbuseReports(filter, page, pageSize)
+    if err != nil {
+        return nil, err
+    }
+    return &model.PaginatedResponse{
+        Data:       reports,
+        Pagination: model.Pagination{Page: page, PageSize: pageSize, Total: int(total)},
+    }, nil
+}
+
+func (s *Service) GetAbuseReport(reportID uint) (*model.AbuseReport, error) {
+    report, err := s.repo.GetAbuseReportByID(reportID)
+    if err != nil {
+        return nil, ErrAbuseReportNotFound
+    }
+    return report, nil
+}
+
+// TriageAbuseReport sets a report's status and resolution notes and can
+// block the reported user on the reporter's behalf, both or neither.
+// Resolved and dismissed reports are final.
+func (s *Service) TriageAbuseReport(admin *model.User, reportID uint, req model.TriageAbuseReportRequest, ipAddress string) (*model.AbuseReport, error) {
+    report, err := s.repo.GetAbuseReportByID(reportID)
+    if errors.Is(err, gorm.ErrRecordNotFound) {
+        return nil, ErrAbuseReportNotFound
+    }
+    if err != nil {
+        return nil, err
+    }
+    if !abuseReportTransitionAllowed(report.Status, req.Status) {
+        return nil, fmt.Errorf("%w: a %s report cannot be set to %s", ErrInvalidTriage, report.Status, req.Status)
+    }
+    var block *model.UserBlock
+    if req.BlockReportedUser {
+        if _, err := s.blockable(report.ReportedUserID); err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrInvalidTriage, err)
+        }
+        block = &model.UserBlock{
+            BlockerID:   report.ReporterID,
+            BlockedID:   report.ReportedUserID,
+            Reason:      fmt.Sprintf("Abuse report %d", report.ID),
+            CreatedByID: &admin.ID,
+        }
+    }
+    if err := s.repo.TriageAbuseReport(reportID, report.Status, req.Status, req.ResolutionNotes, admin.ID, time.Now(), block); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "abuse_report.triaged", "abuse_report", &reportID, map[string]interface{}{
+        "from": report.Status, "to": req.Status, "blocked": req.BlockReportedUser,
+    }, ipAddress)
+    return s.repo.GetAbuseReportByID(reportID)
+}
+
+// BlockUser stops the user and another PIN or CSR rep from seeing,
+// shortlisting or matching each other's requests. Existing matches are
+// left for admins to handle.
+func (s *Service) BlockUser(user *model.User, req model.BlockUserRequest, ipAddress string) (*model.UserBlock, error) {
+    if req.UserID == user.ID {
+        return nil, fmt.Errorf("you cannot block yourself")
+    }
+    target, err := s.blockable(req.UserID)
+    if err != nil {
+        return nil, err
+    }
+    block := &model.UserBlock{BlockerID: user.ID, BlockedID: req.UserID, Reason: strings.TrimSpace(req.Reason)}
+    if err := s.repo.CreateUserBlock(block); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&user.ID, "user.blocked", "user", &req.UserID, nil, ipAddress)
+    block.Blocked = *target
+    return block, nil
+}
+
+// blockable returns the user with the given ID if they can be blocked.
+func (s *Service) blockable(userID uint) (*model.User, error) {
+    target, err := s.repo.GetUserByID(userID)
+    if err != nil {
+        return nil, fmt.Errorf("user not found")
+    }
+    if target.Role != model.RolePIN && target.Role != model.RoleCSRRep {
+        return nil, fmt.Errorf("only PINs and CSR reps can be blocked")
+    }
+    return target, nil
+}
+
+func (s *Service) UnblockUser(user *model.User, blockedID uint, ipAddress string) error {
+    if err := s.repo.DeleteUserBlock(user.ID, blockedID); err != nil {
+        return fmt.Errorf("block not found")
+    }
+    s.RecordAudit(&user.ID, "user.unblocked", "user", &blockedID, nil, ipAddress)
+    return nil
+}
+
+func (s *Service) GetUserBlocks(user *model.User) ([]model.UserBlock, error) {
+    return s.repo.GetUserBlocks(user.ID)
+}
+
+// Tenants
+
+var (
+    ErrUnknownTenant = errors.New("unknown tenant")
+    tenantSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+)
+
+// ResolveTenant returns the active tenant with the given slug.
+func (s *Service) ResolveTenant(slug string) (*model.Tenant, error) {
+    tenant, err := s.repo.GetTenantBySlug(strings.ToLower(slug))
+    if err != nil || !tenant.IsActive {
+        return nil, ErrUnknownTenant
+    }
+    return tenant, nil
+}
+
+// TenantActive reports whether the tenant with the given ID exists and is
+// active.
+func (s *Service) TenantActive(id uint) bool {
+    tenant, err := s.repo.GetTenantByID(id)
+    return err == nil && tenant.IsActive
+}
+
+func (s *Service) ListTenants() ([]model.Tenant, error) {
+    return s.repo.ListTenants()
+}
+
+func (s *Service) CreateTenant(req model.TenantRequest, admin *model.User, ipAddress string) (*model.Tenant, error) {
+    slug := strings.ToLower(req.Slug)
+    if !tenantSlugPattern.MatchString(slug) {
+        return nil, fmt.Errorf("slug must be a valid DNS label")
+    }
+    if _, err := s.repo.GetTenantBySlug(slug); err == nil {
+        return nil, fmt.Errorf("a tenant with this slug already exists")
+    }
+    tenant := &model.Tenant{Slug: slug, Name: req.Name, IsActive: req.IsActive == nil || *req.IsActive}
+    if err := s.repo.CreateTenant(tenant); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "tenant.created", "tenant", &tenant.ID, map[string]interface{}{"slug": tenant.Slug}, ipAddress)
+    return tenant, nil
+}
+
+// UpdateTenant renames a tenant or (de)activates it. The slug is fixed once
+// created since it appears in hostnames; the default tenant stays active.
+func (s *Service) UpdateTenant(id uint, req model.TenantRequest, admin *model.User, ipAddress string) (*model.Tenant, error) {
+    tenant, err := s.repo.GetTenantByID(id)
+    if err != nil {
+        return nil, fmt.Errorf("tenant not found")
+    }
+    if !strings.EqualFold(req.Slug, tenant.Slug) {
+        return nil, fmt.Errorf("slug cannot be changed")
+    }
+    tenant.Name = req.Name
+    if req.IsActive != nil {
+        if !*req.IsActive && tenant.ID == model.DefaultTenantID {
+            return nil, fmt.Errorf("the default tenant cannot be deactivated")
+        }
+        tenant.IsActive = *req.IsActive
+    }
+    if err := s.repo.UpdateTenant(tenant); err != nil {
+        return nil, err
+    }
+    s.RecordAudit(&admin.ID, "tenant.updated", "tenant", &tenant.ID, map[string]interface{}{"name": tenant.Name, "is_active": tenant.IsActive}, ipAddress)
+    return tenant, nil
+}
+
+// GetTenantStats returns the cross-tenant report for super-admins.
+func (s *Service) GetTenantStats(startDate, endDate time.Time) ([]model.TenantStats, error) {
+    if !endDate.After(startDate) {
+        return nil, fmt.Errorf("end_date must be after start_date")
+    }
+    return s.repo.GetTenantStats(startDate, endDate)
+}
@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSessionHours(t *testing.T) {
+	start := time.Date(2024, 5, 12, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		checkOut time.Time
+		want     float64
+	}{
+		{"rounds to two decimals", start.Add(90*time.Minute + 20*time.Second), 1.51},
+		{"full day", start.Add(24 * time.Hour), 24},
+		{"forgotten check-out is capped", start.Add(72 * time.Hour), 24},
+		{"clock skew never goes negative", start.Add(-time.Minute), 0},
+	}
+	for _, tt := range tests {
+		if got := sessionHours(start, tt.checkOut); got != tt.want {
+			t.Errorf("%s: sessionHours = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/config"
+	"csr-volunteer-matching/internal/model"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// signedToken builds a token the way issueAuthToken does, without storing it.
+func signedToken(s *Service, purpose string) string {
+	raw := []byte("0123456789abcdef0123456789abcdef")
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString(raw) + "." + enc.EncodeToString(s.signToken(purpose, raw))
+}
+
+func TestVerifyTokenSignature(t *testing.T) {
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	other := &Service{cfg: &config.Config{TokenSecret: "other-secret"}}
+	valid := signedToken(s, model.TokenPurposePasswordReset)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name  string
+		token string
+		want  bool
+	}{
+		{"valid", valid, true},
+		{"other purpose", signedToken(s, model.TokenPurposeEmailVerification), false},
+		{"other secret", signedToken(other, model.TokenPurposePasswordReset), false},
+		{"tampered", "A" + parts[0][1:] + "." + parts[1], false},
+		{"no signature", parts[0], false},
+		{"extra part", valid + ".x", false},
+		{"bad encoding", parts[0] + ".!!", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		if got := s.verifyTokenSignature(tt.token, model.TokenPurposePasswordReset); got != tt.want {
+			t.Errorf("%s: verifyTokenSignature = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestConsumeAuthTokenRejectsForgeryWithoutLookup(t *testing.T) {
+	// No repository: a forged token must be turned away before any lookup.
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	forged := signedToken(&Service{cfg: &config.Config{TokenSecret: "guess"}}, model.TokenPurposePasswordReset)
+	if _, err := s.consumeAuthToken(forged, model.TokenPurposePasswordReset); err != ErrInvalidToken {
+		t.Errorf("err = %v, want %v", err, ErrInvalidToken)
+	}
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/config"
+	"csr-volunteer-matching/internal/model"
+	"csr-volunteer-matching/internal/totp"
+	"testing"
+	"time"
+)
+
+func TestTwoFactorRequired(t *testing.T) {
+	tests := []struct {
+		role model.UserRole
+		want bool
+	}{
+		{model.RoleAdmin, true},
+		{model.RolePlatform, true},
+		{model.RoleSuperAdmin, true},
+		{model.RoleCompanyAdmin, false},
+		{model.RoleCSRRep, false},
+		{model.RolePIN, false},
+	}
+	for _, tt := range tests {
+		if got := TwoFactorRequired(tt.role); got != tt.want {
+			t.Errorf("TwoFactorRequired(%s) = %v, want %v", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestSealSecret(t *testing.T) {
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	sealed, err := s.sealSecret("session-token")
+	if err != nil {
+		t.Fatalf("sealSecret: %v", err)
+	}
+	if got, err := s.openSecret(sealed); err != nil || got != "session-token" {
+		t.Errorf("openSecret = %q, %v, want %q", got, err, "session-token")
+	}
+	other := &Service{cfg: &config.Config{TokenSecret: "other-secret"}}
+	if _, err := other.openSecret(sealed); err == nil {
+		t.Error("a secret sealed under one key opened under another")
+	}
+	if again, _ := s.sealSecret("session-token"); again == sealed {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+}
+
+func TestNormalizeRecoveryCode(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"abcd-efgh", "abcd-efgh"},
+		{"ABCD-EFGH", "abcd-efgh"},
+		{"abcdefgh", "abcd-efgh"},
+		{" abcd efgh ", "abcd-efgh"},
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		if got := normalizeRecoveryCode(tt.in); got != tt.want {
+			t.Errorf("normalizeRecoveryCode(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBeginTwoFactorLoginWithoutSecondFactor(t *testing.T) {
+	// No repository: a login that needs no second factor stores nothing.
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	response := &model.LoginResponse{Token: "session", User: model.User{ID: 1, Role: model.RoleCSRRep}}
+	challenge, err := s.BeginTwoFactorLogin(response)
+	if err != nil || challenge != nil {
+		t.Errorf("BeginTwoFactorLogin = %v, %v, want no challenge", challenge, err)
+	}
+}
+
+func TestCheckTOTPRejectsBadCodes(t *testing.T) {
+	// No repository: codes that fail validation never reach the replay check.
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatalf("GenerateSecret: %v", err)
+	}
+	sealed, err := s.sealSecret(secret)
+	if err != nil {
+		t.Fatalf("sealSecret: %v", err)
+	}
+	stale, err := totp.CodeAt(secret, totp.Step(time.Now())-5)
+	if err != nil {
+		t.Fatalf("CodeAt: %v", err)
+	}
+	tests := []struct {
+		name string
+		user *model.User
+		code string
+	}{
+		{"not enrolled", &model.User{ID: 1}, "123456"},
+		{"stale code", &model.User{ID: 1, TOTPSecret: sealed}, stale},
+		{"malformed code", &model.User{ID: 1, TOTPSecret: sealed}, "12345"},
+	}
+	for _, tt := range tests {
+		if err := s.checkTOTP(tt.user, tt.code); err != ErrTwoFactorInvalidCode {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, ErrTwoFactorInvalidCode)
+		}
+	}
+}
+
+func TestCompleteTwoFactorLoginRejectsForgedChallenge(t *testing.T) {
+	s := &Service{cfg: &config.Config{TokenSecret: "secret"}}
+	forged := signedToken(&Service{cfg: &config.Config{TokenSecret: "guess"}}, model.TokenPurposeTwoFactorLogin)
+	reset := signedToken(s, model.TokenPurposePasswordReset)
+	for _, challenge := range []string{forged, reset} {
+		if _, _, err := s.CompleteTwoFactorLogin(model.TwoFactorVerifyRequest{ChallengeToken: challenge, Code: "123456"}); err != ErrInvalidToken {
+			t.Errorf("challenge %q: err = %v, want %v", challenge, err, ErrInvalidToken)
+		}
+	}
+}
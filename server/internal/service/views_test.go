@@ -0,0 +1,61 @@
+package service
+
+import (
+	"csr-volunteer-matching/internal/config"
+	"csr-volunteer-matching/internal/model"
+	"testing"
+	"time"
+)
+
+func TestViewTrackerTrack(t *testing.T) {
+	start := time.Date(2024, 5, 12, 9, 0, 0, 0, time.UTC)
+	view := func(rep, request uint, after time.Duration) model.ViewLog {
+		return model.ViewLog{CSRRepID: rep, RequestID: request, CreatedAt: start.Add(after)}
+	}
+	tracker := newViewTracker(nil, &config.Config{ViewDedupWindow: 30 * time.Minute, ViewLogBatchSize: 1})
+
+	steps := []struct {
+		name string
+		view model.ViewLog
+		want bool
+	}{
+		{"first view counts", view(1, 1, 0), true},
+		{"repeat within window", view(1, 1, 10*time.Minute), false},
+		{"other rep", view(2, 1, 10*time.Minute), true},
+		{"other request", view(1, 2, 10*time.Minute), true},
+		{"repeat after window", view(1, 1, 30*time.Minute), true},
+	}
+	for _, step := range steps {
+		if got := tracker.track(step.view); got != step.want {
+			t.Errorf("%s: track = %v, want %v", step.name, got, step.want)
+		}
+	}
+}
+
+func TestViewTrackerQueueFull(t *testing.T) {
+	tracker := newViewTracker(nil, &config.Config{ViewDedupWindow: time.Hour, ViewLogBatchSize: 1})
+	now := time.Now()
+	for i := 0; i < cap(tracker.queue); i++ {
+		if !tracker.track(model.ViewLog{CSRRepID: 1, RequestID: uint(i + 1), CreatedAt: now}) {
+			t.Fatalf("view %d was not queued", i+1)
+		}
+	}
+	dropped := model.ViewLog{CSRRepID: 2, RequestID: 1, CreatedAt: now}
+	if tracker.track(dropped) {
+		t.Fatal("view was counted although the queue is full")
+	}
+	<-tracker.queue
+	if !tracker.track(dropped) {
+		t.Error("a view dropped on a full queue blocked the retry")
+	}
+}
+
+func TestNewViewTrackerDefaults(t *testing.T) {
+	tracker := newViewTracker(nil, &config.Config{})
+	if tracker.flushInterval != defaultViewFlushInterval {
+		t.Errorf("flushInterval = %v, want %v", tracker.flushInterval, defaultViewFlushInterval)
+	}
+	if tracker.batchSize != 1 {
+		t.Errorf("batchSize = %d, want 1", tracker.batchSize)
+	}
+}
@@ -0,0 +1,100 @@
+// Package tenancy carries the current tenant in a context and scopes GORM
+// statements to it.
+package tenancy
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type contextKey struct{}
+
+// WithTenant returns a context whose statements are scoped to tenantID.
+func WithTenant(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// WithoutTenant returns a context whose statements see every tenant, even if
+// ctx was scoped. It is used for lookups that discover the tenant, such as
+// token validation, and for super-admin requests.
+func WithoutTenant(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, uint(0))
+}
+
+// FromContext returns the tenant ctx is scoped to. A context without a tenant
+// is unscoped.
+func FromContext(ctx context.Context) (uint, bool) {
+	if ctx == nil {
+		return 0, false
+	}
+	id, _ := ctx.Value(contextKey{}).(uint)
+	return id, id != 0
+}
+
+// GormPlugin scopes statements on models with a TenantID field to the tenant
+// of the statement context: queries, updates and deletes get a tenant_id
+// condition and created rows are stamped with the tenant. Statements built
+// with Table or Raw have no model and must filter by tenant themselves.
+type GormPlugin struct{}
+
+func (GormPlugin) Name() string { return "tenancy" }
+
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	if err := cb.Create().Before("gorm:create").Register("tenancy:create", stampTenant); err != nil {
+		return err
+	}
+	if err := cb.Query().Before("gorm:query").Register("tenancy:query", scopeTenant); err != nil {
+		return err
+	}
+	if err := cb.Update().Before("gorm:update").Register("tenancy:update", scopeTenant); err != nil {
+		return err
+	}
+	if err := cb.Delete().Before("gorm:delete").Register("tenancy:delete", scopeTenant); err != nil {
+		return err
+	}
+	return cb.Row().Before("gorm:row").Register("tenancy:row", scopeTenant)
+}
+
+func scopeTenant(tx *gorm.DB) {
+	tenantID, ok := FromContext(tx.Statement.Context)
+	if !ok || tx.Statement.Schema == nil || tx.Statement.Schema.LookUpField("TenantID") == nil {
+		return
+	}
+	tx.Statement.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: "tenant_id"}, Value: tenantID},
+	}})
+}
+
+func stampTenant(tx *gorm.DB) {
+	tenantID, ok := FromContext(tx.Statement.Context)
+	if !ok || tx.Statement.Schema == nil {
+		return
+	}
+	field := tx.Statement.Schema.LookUpField("TenantID")
+	if field == nil {
+		return
+	}
+	ctx := tx.Statement.Context
+	stamp := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		if _, zero := field.ValueOf(ctx, rv); zero {
+			_ = field.Set(ctx, rv, tenantID)
+		}
+	}
+	rv := tx.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			stamp(rv.Index(i))
+		}
+	case reflect.Struct:
+		stamp(rv)
+	}
+}
@@ -0,0 +1,65 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the RFC 6238 SHA-1 test key "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeAt(t *testing.T) {
+	// The RFC lists 8-digit codes; these are their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		got, err := CodeAt(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("CodeAt(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("CodeAt(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	code := func(offset int64) string {
+		c, err := CodeAt(rfcSecret, Step(now)+offset)
+		if err != nil {
+			t.Fatalf("CodeAt: %v", err)
+		}
+		return c
+	}
+	tests := []struct {
+		name     string
+		code     string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", code(0), Step(now), true},
+		{"previous step", code(-1), Step(now) - 1, true},
+		{"next step", code(1), Step(now) + 1, true},
+		{"outside skew", code(-2), 0, false},
+		{"spaced", code(0)[:3] + " " + code(0)[3:], Step(now), true},
+		{"too short", code(0)[:5], 0, false},
+		{"wrong", "000000", 0, false},
+	}
+	for _, tt := range tests {
+		step, ok := Validate(rfcSecret, tt.code, now, 1)
+		if ok != tt.wantOK || step != tt.wantStep {
+			t.Errorf("%s: Validate = %d, %v, want %d, %v", tt.name, step, ok, tt.wantStep, tt.wantOK)
+		}
+	}
+	if _, ok := Validate("not base32!", code(0), now, 1); ok {
+		t.Error("a code was accepted for a malformed secret")
+	}
+}
@@ -0,0 +1,40 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestEnd(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus codes.Code
+		wantEvents int
+	}{
+		{"success", nil, codes.Unset, 0},
+		{"failure", errors.New("boom"), codes.Error, 1},
+	}
+	for _, tt := range tests {
+		recorder := tracetest.NewSpanRecorder()
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		_, span := provider.Tracer("test").Start(context.Background(), tt.name)
+		End(span, tt.err)
+
+		ended := recorder.Ended()
+		if len(ended) != 1 {
+			t.Fatalf("%s: %d spans ended, want 1", tt.name, len(ended))
+		}
+		if got := ended[0].Status().Code; got != tt.wantStatus {
+			t.Errorf("%s: status = %v, want %v", tt.name, got, tt.wantStatus)
+		}
+		if got := len(ended[0].Events()); got != tt.wantEvents {
+			t.Errorf("%s: %d events, want %d", tt.name, got, tt.wantEvents)
+		}
+	}
+}